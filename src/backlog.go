@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policies for messages sent while the bot was offline
+const (
+	backlogIgnore       = "ignore"        // not answered and not added to the history
+	backlogHistory      = "history"       // added to the history without an answer
+	backlogAnswerLatest = "answer-latest" // added to the history, the latest message for the bot in a chat is answered
+)
+
+const (
+	// Telegram picks update ids randomly after a week without updates, an older offset could skip new ones
+	maxOffsetAge = 6 * 24 * time.Hour
+
+	// defaultBacklogSettle is how long a backlog message waits for later ones of the same chat
+	defaultBacklogSettle = 3 * time.Second
+)
+
+// updateOffset keeps the last handled update id in a small file in the history directory,
+// so it is saved whether histories are saved or not
+type updateOffset struct {
+	store Store
+	key   string
+}
+
+type savedOffset struct {
+	LastUpdateID int       `json:"lastUpdateId"`
+	SavedAt      time.Time `json:"savedAt"`
+}
+
+// newUpdateOffset uses <config name>.offset.json in dir, the config directory is often read-only
+func newUpdateOffset(dir, configFile string) (*updateOffset, error) {
+	store, err := NewFileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(configFile)
+	return &updateOffset{store: store, key: strings.TrimSuffix(name, filepath.Ext(name)) + ".offset"}, nil
+}
+
+// Load returns the last update id handled before the restart, 0 when it is unknown or too old
+func (o *updateOffset) Load() (int, error) {
+	data, err := o.store.Load(o.key)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var offset savedOffset
+	if err := json.Unmarshal(data, &offset); err != nil {
+		return 0, err
+	}
+	if time.Since(offset.SavedAt) > maxOffsetAge {
+		logTelegram.Info("Saved update offset is too old, ignoring it", "saved", offset.SavedAt)
+		return 0, nil
+	}
+	return offset.LastUpdateID, nil
+}
+
+func (o *updateOffset) Save(id int) error {
+	data, err := json.Marshal(savedOffset{LastUpdateID: id, SavedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return o.store.Save(o.key, data)
+}
+
+// runOffsetSaver saves the last handled update id on every tick and when ctx ends
+func runOffsetSaver(ctx context.Context, offset *updateOffset, updates *updateTracker, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSaveInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var saved int
+	save := func() {
+		id := updates.Processed()
+		if id == saved {
+			return
+		}
+		if err := offset.Save(id); err != nil {
+			logTelegram.Error("Error saving update offset", "error", err)
+			return
+		}
+		saved = id
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			save()
+		case <-ctx.Done():
+			save()
+			return
+		}
+	}
+}
+
+// updateTracker finds the last update that was handled together with all updates before it.
+// Handlers run concurrently, updates still running or dropped at shutdown keep the offset before them
+type updateTracker struct {
+	mu        sync.Mutex
+	pending   []int       // ids in the order they came
+	running   map[int]int // handlers and backlog answers still using the update
+	processed int
+}
+
+// start registers an update before its handler runs
+func (t *updateTracker) start(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running == nil {
+		t.running = make(map[int]int)
+	}
+	t.pending = append(t.pending, id)
+	t.running[id] = 1
+}
+
+// hold keeps a started update unhandled until the matching done, backlog answers run after their handler
+func (t *updateTracker) hold(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.running[id]; ok {
+		t.running[id]++
+	}
+}
+
+// done ends the handler or hold of the update
+func (t *updateTracker) done(id int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.running[id]; !ok {
+		return
+	}
+	t.running[id]--
+
+	for len(t.pending) > 0 && t.running[t.pending[0]] == 0 {
+		t.processed = t.pending[0]
+		delete(t.running, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+}
+
+// Processed returns the last update id handled together with all updates before it
+func (t *updateTracker) Processed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.processed
+}
+
+// backlogTracker answers only the latest backlog message for the bot in every chat
+type backlogTracker struct {
+	mu      sync.Mutex
+	pending map[int64]*pendingBacklog
+	settle  time.Duration
+}
+
+type pendingBacklog struct {
+	messageID int
+	timer     *time.Timer
+	drop      func()
+}
+
+// schedule runs answer after the settle time, a later backlog message of the chat cancels it.
+// drop is called instead of answer for messages that are not answered
+func (t *backlogTracker) schedule(chatID int64, messageID int, answer, drop func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[int64]*pendingBacklog)
+	}
+	if p, ok := t.pending[chatID]; ok {
+		if messageID <= p.messageID {
+			drop()
+			return
+		}
+		if p.timer.Stop() {
+			p.drop()
+		}
+	}
+
+	settle := t.settle
+	if settle <= 0 {
+		settle = defaultBacklogSettle
+	}
+
+	p := &pendingBacklog{messageID: messageID, drop: drop}
+	p.timer = time.AfterFunc(settle, func() {
+		t.mu.Lock()
+		latest := t.pending[chatID] == p
+		t.mu.Unlock()
+
+		if latest {
+			answer()
+		} else {
+			drop()
+		}
+	})
+	t.pending[chatID] = p
+}
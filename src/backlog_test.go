@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateOffset(t *testing.T) {
+	dir := t.TempDir()
+	offset, err := newUpdateOffset(dir, "/etc/bot/bot.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := offset.Load(); err != nil || id != 0 {
+		t.Errorf("expected no offset, got %d %v", id, err)
+	}
+
+	var updates updateTracker
+	updates.start(42)
+	updates.done(42)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runOffsetSaver(ctx, offset, &updates, time.Minute)
+
+	if _, err := os.Stat(filepath.Join(dir, "bot.offset.json")); err != nil {
+		t.Errorf("offset must be saved in the history directory: %v", err)
+	}
+	if id, err := offset.Load(); err != nil || id != 42 {
+		t.Errorf("expected offset 42, got %d %v", id, err)
+	}
+
+	// Update ids may start anew after a week without updates
+	data, _ := json.Marshal(savedOffset{LastUpdateID: 42, SavedAt: time.Now().Add(-8 * 24 * time.Hour)})
+	offset.store.Save(offset.key, data)
+	if id, err := offset.Load(); err != nil || id != 0 {
+		t.Errorf("old offset must be ignored, got %d %v", id, err)
+	}
+}
+
+func TestUpdateTracker(t *testing.T) {
+	var updates updateTracker
+	for _, id := range []int{10, 11, 12, 13} {
+		updates.start(id)
+	}
+
+	// 11 is still running, later updates that finished must not be saved
+	updates.done(12)
+	updates.done(10)
+	if id := updates.Processed(); id != 10 {
+		t.Errorf("expected 10, got %d", id)
+	}
+
+	// A held backlog message stays unhandled until its answer
+	updates.hold(11)
+	updates.done(11)
+	if id := updates.Processed(); id != 10 {
+		t.Errorf("held update must not be processed, got %d", id)
+	}
+	updates.done(11)
+	if id := updates.Processed(); id != 12 {
+		t.Errorf("expected 12, got %d", id)
+	}
+
+	// 13 was dropped at shutdown and never finishes
+	updates.done(99)
+	if id := updates.Processed(); id != 12 {
+		t.Errorf("expected 12, got %d", id)
+	}
+}
+
+func TestBacklogSchedule(t *testing.T) {
+	tracker := &backlogTracker{settle: 20 * time.Millisecond}
+
+	var (
+		mu       sync.Mutex
+		answered []int
+		dropped  []int
+	)
+	add := func(list *[]int, id int) func() {
+		return func() {
+			mu.Lock()
+			*list = append(*list, id)
+			mu.Unlock()
+		}
+	}
+	schedule := func(chatID int64, id int) {
+		tracker.schedule(chatID, id, add(&answered, id), add(&dropped, id))
+	}
+
+	for _, id := range []int{10, 11, 12} {
+		schedule(1, id)
+	}
+	schedule(1, 9)
+	// Another chat is answered on its own
+	schedule(2, 5)
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	slices.Sort(answered)
+	if !slices.Equal(answered, []int{5, 12}) {
+		t.Errorf("only the latest message of every chat must be answered, got %v", answered)
+	}
+	slices.Sort(dropped)
+	if !slices.Equal(dropped, []int{9, 10, 11}) {
+		t.Errorf("replaced messages must be dropped, got %v", dropped)
+	}
+}
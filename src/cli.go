@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Build information, set with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+const defaultConfigFile = "config.json"
+
+var errFlagParse = errors.New("invalid flags")
+
+// cliCommand is a subcommand of the bot binary
+type cliCommand struct {
+	name        string
+	description string
+	run         func(args []string, stdout, stderr io.Writer) error
+}
+
+func cliCommands() []cliCommand {
+	return []cliCommand{
+		{"run", "Start the bot (default)", cliRun},
+		{"check-config", "Validate the config and print it without secrets", cliCheckConfig},
+		{"export-history", "Write the stored history of a chat as JSON", cliExportHistory},
+		{"import-history", "Replace the stored history of a chat with a JSON file", cliImportHistory},
+		{"list-models", "List models of an LLM provider", cliListModels},
+		{"version", "Print the version", cliVersion},
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: bot [command] [flags]\n\nCommands:\n")
+	for _, cmd := range cliCommands() {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(w, "\nRun 'bot <command> -h' to see the flags of a command.\n")
+}
+
+// runCLI runs the command given in args and returns the exit code
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		printUsage(stdout)
+		return 0
+	}
+	if name == "help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, cmd := range cliCommands() {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(args, stdout, stderr)
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.Is(err, errFlagParse):
+			return 2
+		case err != nil:
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %q\n\n", name)
+	printUsage(stderr)
+	return 2
+}
+
+// newFlagSet creates flags of a command, every command reads the config given with -c
+func newFlagSet(name, usage string, stderr io.Writer) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: bot %s %s\n\nFlags:\n", name, usage)
+		fs.PrintDefaults()
+	}
+
+	configFile := fs.String("c", defaultConfigFile, "config file: .json, .yaml, .yml or .toml")
+	return fs, configFile
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errFlagParse
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return errFlagParse
+	}
+	return nil
+}
+
+func cliRun(args []string, stdout, stderr io.Writer) error {
+	fs, configFile := newFlagSet("run", "[-c config.json]", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	runBot(*configFile)
+	return nil
+}
+
+func cliCheckConfig(args []string, stdout, stderr io.Writer) error {
+	fs, configFile := newFlagSet("check-config", "[-c config.json]", stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config.redacted(), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\n", data)
+	fmt.Fprintf(stderr, "Config %s is valid\n", *configFile)
+	return nil
+}
+
+// openHistoryStore opens the store the bot saves histories to
+func openHistoryStore(configFile string) (*Config, Store, error) {
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !config.EnableSaveHistory {
+		return nil, nil, errors.New("enableSaveHistory is off, the bot does not store histories")
+	}
+
+	store, err := newStore(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, store, nil
+}
+
+func cliExportHistory(args []string, stdout, stderr io.Writer) error {
+	fs, configFile := newFlagSet("export-history", "-chat ID [-o file] [-c config.json]", stderr)
+	chatID := fs.Int64("chat", 0, "chat id, required")
+	output := fs.String("o", "-", "output file, - for stdout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *chatID == 0 {
+		fmt.Fprintf(stderr, "-chat is required\n")
+		fs.Usage()
+		return errFlagParse
+	}
+
+	_, store, err := openHistoryStore(*configFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	data, err := store.Load(historyKey(*chatID))
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("no stored history for chat %d", *chatID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		_, err = stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0o600)
+}
+
+func cliImportHistory(args []string, stdout, stderr io.Writer) error {
+	fs, configFile := newFlagSet("import-history", "-chat ID -i file [-force] [-c config.json]", stderr)
+	chatID := fs.Int64("chat", 0, "chat id, required")
+	input := fs.String("i", "", "exported history file, - for stdin, required")
+	force := fs.Bool("force", false, "replace the history if the chat already has one")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *chatID == 0 || *input == "" {
+		fmt.Fprintf(stderr, "-chat and -i are required\n")
+		fs.Usage()
+		return errFlagParse
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if *input == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*input)
+	}
+	if err != nil {
+		return err
+	}
+
+	config, store, err := openHistoryStore(*configFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	chat := NewChatContext(*chatID, config.HistorySize)
+	if err := json.Unmarshal(data, chat); err != nil {
+		return fmt.Errorf("parse %s: %w", *input, err)
+	}
+
+	if _, err := store.Load(historyKey(*chatID)); err == nil && !*force {
+		return fmt.Errorf("chat %d already has a history, use -force to replace it", *chatID)
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	chat.version.Add(1)
+	if err := chat.save(store); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Imported %d messages and %d memory facts to chat %d\n", len(chat.History.GetAll()), len(chat.Memory.GetAll()), *chatID)
+	if config.EmbeddingModel != "" {
+		fmt.Fprintf(stdout, "Run /reindex in the chat to rebuild its search index\n")
+	}
+	return nil
+}
+
+func cliListModels(args []string, stdout, stderr io.Writer) error {
+	fs, configFile := newFlagSet("list-models", "[-provider name] [-c config.json]", stderr)
+	providerName := fs.String("provider", "", "provider name, the default provider when empty")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+
+	ollamaClient, err := newOllamaClient(config, config.ServerURL)
+	if err != nil {
+		return err
+	}
+	providers, err := newProviders(config, ollamaClient)
+	if err != nil {
+		return err
+	}
+
+	name := *providerName
+	if name == "" {
+		name = config.DefaultProvider
+	}
+	if name == "" {
+		name = providerOllama
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return fmt.Errorf("unknown provider %s", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	models, err := provider.Models(ctx)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		fmt.Fprintln(stdout, model)
+	}
+	return nil
+}
+
+func cliVersion(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "ollama-telegram-bot %s\n", versionString())
+	return nil
+}
+
+// versionString describes the build, the vcs revision is used when commit is not set
+func versionString() string {
+	rev, built := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok && rev == "" {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				rev = setting.Value
+			case "vcs.time":
+				built = setting.Value
+			}
+		}
+	}
+
+	s := version
+	if rev != "" {
+		s += " (" + rev
+		if built != "" {
+			s += ", " + built
+		}
+		s += ")"
+	}
+	return s + " " + runtime.Version() + " " + runtime.GOOS + "/" + runtime.GOARCH
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runTestCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := runCLI(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIUsage(t *testing.T) {
+	code, out, _ := runTestCLI("--help")
+	if code != 0 || !strings.Contains(out, "export-history") {
+		t.Errorf("unexpected help: %d %s", code, out)
+	}
+
+	code, _, errOut := runTestCLI("unknown")
+	if code != 2 || !strings.Contains(errOut, "Unknown command") {
+		t.Errorf("unexpected result for unknown command: %d %s", code, errOut)
+	}
+
+	code, _, errOut = runTestCLI("check-config", "-unknown")
+	if code != 2 || !strings.Contains(errOut, "Usage: bot check-config") {
+		t.Errorf("unexpected result for unknown flag: %d %s", code, errOut)
+	}
+
+	code, out, _ = runTestCLI("version")
+	if code != 0 || !strings.HasPrefix(out, "ollama-telegram-bot dev") {
+		t.Errorf("unexpected version: %d %s", code, out)
+	}
+}
+
+func TestCLICheckConfig(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"botToken": "123:secret", "model": "llama"}`)
+
+	code, out, errOut := runTestCLI("check-config", "-c", path)
+	if code != 0 {
+		t.Fatalf("check-config failed: %s", errOut)
+	}
+	if strings.Contains(out, "123:secret") || !strings.Contains(out, `"model": "llama"`) {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	bad := writeConfig(t, "bad.json", `{"model": "llama"}`)
+	if code, _, errOut := runTestCLI("check-config", "-c", bad); code != 1 || !strings.Contains(errOut, "botToken") {
+		t.Errorf("expected validation error, got %d %s", code, errOut)
+	}
+}
+
+func TestCLIHistory(t *testing.T) {
+	dir := t.TempDir()
+	config := fmt.Sprintf(`{"botToken": "t", "model": "llama", "enableSaveHistory": true, "historyDir": %q}`, dir)
+	path := writeConfig(t, "config.json", config)
+
+	export := filepath.Join(dir, "export.json")
+	if err := os.WriteFile(export, []byte(`{"history": {"data": [{"user_type": "user", "message": "hi"}]}, "memory": {"data": ["likes cats"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, out, errOut := runTestCLI("import-history", "-c", path, "-chat", "-100", "-i", export)
+	if code != 0 || !strings.Contains(out, "Imported 1 messages and 1 memory facts") {
+		t.Fatalf("import failed: %d %s %s", code, out, errOut)
+	}
+
+	if code, _, errOut := runTestCLI("import-history", "-c", path, "-chat", "-100", "-i", export); code != 1 || !strings.Contains(errOut, "-force") {
+		t.Errorf("import over an existing history must need -force: %d %s", code, errOut)
+	}
+
+	code, out, errOut = runTestCLI("export-history", "-c", path, "-chat", "-100")
+	if code != 0 || !strings.Contains(out, `"hi"`) || !strings.Contains(out, "likes cats") {
+		t.Errorf("unexpected export: %d %s %s", code, out, errOut)
+	}
+
+	if code, _, errOut := runTestCLI("export-history", "-c", path, "-chat", "-200"); code != 1 || !strings.Contains(errOut, "no stored history") {
+		t.Errorf("unexpected result for missing chat: %d %s", code, errOut)
+	}
+
+	if code, _, _ := runTestCLI("export-history", "-c", path); code != 2 {
+		t.Errorf("-chat must be required")
+	}
+}
+
+func TestCLIListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"models": [{"name": "llama:latest"}, {"name": "qwen:7b"}]}`))
+	}))
+	defer server.Close()
+
+	path := writeConfig(t, "config.json", fmt.Sprintf(`{"botToken": "t", "model": "llama", "serverUrl": %q}`, server.URL))
+
+	code, out, errOut := runTestCLI("list-models", "-c", path)
+	if code != 0 || out != "llama:latest\nqwen:7b\n" {
+		t.Errorf("unexpected models: %d %q %s", code, out, errOut)
+	}
+
+	if code, _, errOut := runTestCLI("list-models", "-c", path, "-provider", "missing"); code != 1 || !strings.Contains(errOut, "unknown provider") {
+		t.Errorf("unexpected result for unknown provider: %d %s", code, errOut)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/telebot.v3"
+)
+
+// errUsage makes the command reply with its usage
+var errUsage = errors.New("wrong arguments")
+
+type commandHandler func(c telebot.Context, chat *ChatContext, args string) (string, error)
+
+type command struct {
+	name        string
+	aliases     []string // phrases recognized after a bot mention
+	args        string
+	description string
+	help        string
+	minRole     Role
+	handler     commandHandler
+}
+
+func (cmd *command) usage() string {
+	if cmd.args == "" {
+		return "/" + cmd.name
+	}
+	return fmt.Sprintf("/%s %s", cmd.name, cmd.args)
+}
+
+func (b *bot) newCommands() []*command {
+	return []*command{
+		{
+			name:        "remember",
+			aliases:     []string{"запомни"},
+			args:        "<text>",
+			description: "Remember a fact for this chat",
+			help:        "Adds the text to the chat memory, it is given to the model with every request.",
+			minRole:     RoleAdmin,
+			handler:     b.cmdRemember,
+		},
+		{
+			name:        "memory",
+			aliases:     []string{"что ты помнишь"},
+			description: "Show remembered facts",
+			help:        "Lists the chat memory with numbers that /forget accepts.",
+			handler:     b.cmdMemory,
+		},
+		{
+			name:        "forget",
+			aliases:     []string{"забудь"},
+			args:        "<number>",
+			description: "Forget a remembered fact",
+			help:        "Removes a fact from the chat memory by its number from /memory.",
+			minRole:     RoleAdmin,
+			handler:     b.cmdForget,
+		},
+		{
+			name:        "search",
+			args:        "<query>",
+			description: "Search memory and archived messages",
+			help:        "Shows memory facts and archived messages most similar to the query by meaning.",
+			handler:     b.cmdSearch,
+		},
+		{
+			name:        "reindex",
+			description: "Rebuild the memory search index",
+			help:        "Embeds memory facts and archived messages of this chat again, needed after changing the embedding model.",
+			minRole:     RoleAdmin,
+			handler:     b.cmdReindex,
+		},
+		{
+			name:        "reset",
+			description: "Clear the conversation history",
+			help:        "Clears the conversation history, its summary and archived messages in this chat, the memory is kept.",
+			minRole:     RoleAdmin,
+			handler:     b.cmdReset,
+		},
+		{
+			name:        "model",
+			args:        "[name]",
+			description: "Show or change the model",
+			help:        "Without arguments shows the model of this chat and models available on the server, with a name switches the chat to that model (admins).",
+			handler:     b.cmdModel,
+		},
+		{
+			name:        "system",
+			args:        "[prompt]",
+			description: "Show or change the system prompt",
+			help:        "Without arguments shows the system prompt of this chat, with a text replaces it (admins).",
+			handler:     b.cmdSystem,
+		},
+		{
+			name:        "settings",
+			description: "Show chat settings",
+			help:        "Shows settings overridden in this chat and the effective model options.",
+			handler:     b.cmdSettings,
+		},
+		{
+			name:        "set",
+			args:        "<name> <value>",
+			description: "Change a chat setting",
+			help:        "Overrides a setting for this chat (admins). Settings: " + strings.Join(SettingNames(), ", "),
+			minRole:     RoleAdmin,
+			handler:     b.cmdSet,
+		},
+		{
+			name:        "unset",
+			args:        "<name>",
+			description: "Reset a chat setting to default",
+			help:        "Returns a setting of this chat to the config value (admins).",
+			minRole:     RoleAdmin,
+			handler:     b.cmdUnset,
+		},
+		{
+			name:        "stats",
+			description: "Show bot statistics",
+			help:        "Shows uptime, history size, memory size and the request queue length.",
+			handler:     b.cmdStats,
+		},
+		{
+			name:        "help",
+			args:        "[command]",
+			description: "List commands or show help for one",
+			help:        "Without arguments lists every command, with a command name shows its help.",
+			handler:     b.cmdHelp,
+		},
+	}
+}
+
+// registerCommands adds handlers for every command and publishes them to the telegram menu
+func (b *bot) registerCommands(tgBot *telebot.Bot) {
+	menu := make([]telebot.Command, 0, len(b.commands))
+
+	for _, cmd := range b.commands {
+		tgBot.Handle("/"+cmd.name, b.botMiddleware(b.handleCommand(cmd)))
+		menu = append(menu, telebot.Command{Text: cmd.name, Description: cmd.description})
+	}
+
+	if err := tgBot.SetCommands(menu); err != nil {
+		logCommands.Error("Error publishing commands", "error", err)
+	}
+}
+
+func (b *bot) handleCommand(cmd *command) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		rs, err := b.runCommand(c, cmd, strings.TrimSpace(c.Message().Payload))
+
+		var roleErr *roleError
+		switch {
+		case errors.Is(err, errUsage):
+			rs = fmt.Sprintf("Usage: %s\n%s", cmd.usage(), cmd.help)
+		case errors.As(err, &roleErr):
+			rs = roleErr.message()
+		case err != nil:
+			logCommands.Error("Command failed", "command", cmd.name, "error", err)
+			rs = fmt.Sprintf("Error: %v", err)
+		}
+
+		return b.send(rs, c)
+	}
+}
+
+// runCommand checks the sender role and runs the command
+func (b *bot) runCommand(c telebot.Context, cmd *command, args string) (string, error) {
+	if err := b.requireRole(c, cmd.minRole); err != nil {
+		return "", err
+	}
+
+	return cmd.handler(c, b.chatContexts.Get(c.Chat().ID), args)
+}
+
+// findAlias looks for a command whose alias starts the text, returning the rest of the text as arguments
+func (b *bot) findAlias(text string) (*command, string) {
+	lower := strings.ToLower(text)
+
+	for _, cmd := range b.commands {
+		for _, alias := range cmd.aliases {
+			if !strings.HasPrefix(lower, alias) {
+				continue
+			}
+
+			args := text[len(alias):]
+			// Alias must be a whole word
+			if r, _ := utf8.DecodeRuneInString(args); unicode.IsLetter(r) || unicode.IsDigit(r) {
+				continue
+			}
+
+			return cmd, strings.TrimSpace(strings.TrimLeft(args, " ,:;.!?"))
+		}
+	}
+
+	return nil, ""
+}
+
+func (b *bot) cmdRemember(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	if args == "" {
+		return "", errUsage
+	}
+
+	chat.Memory.Add(args)
+	return fmt.Sprintf("Теперь я помню: %s", args), nil
+}
+
+func (b *bot) cmdMemory(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	list := chat.Memory.GetList()
+	if list == "" {
+		return "Memory is empty.", nil
+	}
+
+	return fmt.Sprintf("Меня просили запомнить:\n%s", list), nil
+}
+
+func (b *bot) cmdForget(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	index, err := strconv.Atoi(args)
+	if err != nil {
+		return "", errUsage
+	}
+
+	old, ok := chat.Memory.Remove(index - 1)
+	if !ok {
+		return "", fmt.Errorf("no fact with number %d", index)
+	}
+
+	return fmt.Sprintf("Забыл: %s", old), nil
+}
+
+func (b *bot) cmdSearch(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	if args == "" {
+		return "", errUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+
+	matches, err := b.recall(ctx, chat, args, b.memoryTopK())
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "Nothing found.", nil
+	}
+
+	rs := "Found:"
+	for _, m := range matches {
+		rs += fmt.Sprintf("\n%.2f [%s] %s", m.Score, m.Source, m.Text)
+	}
+
+	return rs, nil
+}
+
+func (b *bot) cmdReindex(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reindexTimeout)
+	defer cancel()
+
+	n, err := b.reindex(ctx, chat)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Reindexed %d entries with %s", n, b.cfg().EmbeddingModel), nil
+}
+
+func (b *bot) cmdReset(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	chat.History.Clear()
+	chat.Summary.Clear()
+	chat.Vectors.ClearHistory()
+	return "History cleared.", nil
+}
+
+func (b *bot) cmdModel(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if args != "" {
+		if err := b.requireRole(c, RoleAdmin); err != nil {
+			return "", err
+		}
+
+		models, err := b.chatProvider(chat).Models(ctx)
+		if err != nil {
+			return "", fmt.Errorf("model %s is not available: %w", args, err)
+		}
+		if !slices.ContainsFunc(models, func(name string) bool { return modelMatches(name, args) }) {
+			return "", fmt.Errorf("model %s is not available", args)
+		}
+
+		if err := chat.Settings.Set(settingModel, args); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Model changed to %s", args), nil
+	}
+
+	rs := fmt.Sprintf("Model: %s\nProvider: %s", b.chatModel(chat), b.chatProviderName(chat))
+
+	models, err := b.chatProvider(chat).Models(ctx)
+	if err != nil {
+		logCommands.Warn("Error listing models", "error", err)
+		return rs, nil
+	}
+
+	rs += "\n\nAvailable models:"
+	for _, model := range models {
+		rs += "\n- " + model
+	}
+
+	return rs, nil
+}
+
+func (b *bot) cmdSystem(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	if args != "" {
+		if err := b.requireRole(c, RoleAdmin); err != nil {
+			return "", err
+		}
+
+		if err := chat.Settings.Set(settingSystem, args); err != nil {
+			return "", err
+		}
+		return "System prompt changed.", nil
+	}
+
+	return fmt.Sprintf("System prompt:\n%s", b.chatSystemPrompt(chat)), nil
+}
+
+func (b *bot) cmdSettings(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	list := chat.Settings.List()
+
+	rs := fmt.Sprintf("Model: %s", b.chatModel(chat))
+
+	options, err := json.Marshal(b.chatOptions(chat))
+	if err != nil {
+		return "", err
+	}
+	rs += fmt.Sprintf("\nOptions: %s", options)
+
+	if len(list) == 0 {
+		return rs + "\n\nNo settings are overridden in this chat.", nil
+	}
+
+	keys := make([]string, 0, len(list))
+	for key := range list {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rs += "\n\nOverridden in this chat:"
+	for _, key := range keys {
+		rs += fmt.Sprintf("\n%s = %s", key, list[key])
+	}
+
+	return rs, nil
+}
+
+func (b *bot) cmdSet(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	key, value, _ := strings.Cut(args, " ")
+	value = strings.TrimSpace(value)
+	if key == "" || value == "" {
+		return "", errUsage
+	}
+
+	if key == settingModel {
+		return b.cmdModel(c, chat, value)
+	}
+
+	if err := chat.Settings.Set(key, value); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s = %s", key, value), nil
+}
+
+func (b *bot) cmdUnset(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	if args == "" {
+		return "", errUsage
+	}
+
+	if err := chat.Settings.Set(args, ""); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s reset to default", args), nil
+}
+
+func (b *bot) cmdStats(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	rs := fmt.Sprintf("Uptime: %s\nModel: %s\nHistory: %d messages\nMemory: %d facts\nQueue: %d requests",
+		time.Since(b.startTime).Round(time.Second),
+		b.chatModel(chat),
+		len(chat.History.GetAll()),
+		chat.Memory.Len(),
+		b.queue.Len(),
+	)
+
+	if b.cfg().EmbeddingModel != "" {
+		rs += fmt.Sprintf("\nSearch index: %d entries", chat.Vectors.Len())
+	}
+
+	return rs, nil
+}
+
+func (b *bot) cmdHelp(c telebot.Context, chat *ChatContext, args string) (string, error) {
+	if args != "" {
+		name := strings.TrimPrefix(args, "/")
+		for _, cmd := range b.commands {
+			if cmd.name == name {
+				rs := fmt.Sprintf("%s\n%s", cmd.usage(), cmd.help)
+				if cmd.minRole > RoleUser {
+					rs += fmt.Sprintf("\nRequires the %s role.", cmd.minRole)
+				}
+				if len(cmd.aliases) > 0 {
+					rs += fmt.Sprintf("\nAlso: @%s %s", c.Bot().Me.Username, strings.Join(cmd.aliases, ", "))
+				}
+				return rs, nil
+			}
+		}
+		return "", fmt.Errorf("unknown command %s", args)
+	}
+
+	rs := "Commands:"
+	for _, cmd := range b.commands {
+		rs += fmt.Sprintf("\n%s - %s", cmd.usage(), cmd.description)
+		if cmd.minRole > RoleUser {
+			rs += fmt.Sprintf(" (%s)", cmd.minRole)
+		}
+	}
+
+	return rs, nil
+}
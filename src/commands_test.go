@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFindAlias(t *testing.T) {
+	b := &bot{}
+	b.commands = b.newCommands()
+
+	testCases := []struct {
+		input   string
+		command string
+		args    string
+	}{
+		{input: "запомни кот любит рыбу", command: "remember", args: "кот любит рыбу"},
+		{input: "Запомни кот", command: "remember", args: "кот"},
+		{input: "что ты помнишь?", command: "memory", args: ""},
+		{input: "запомни: кот", command: "remember", args: "кот"},
+		{input: "что ты помнишь", command: "memory", args: ""},
+		{input: "забудь 2", command: "forget", args: "2"},
+		{input: "запомнишь меня?", command: "", args: ""},
+		{input: "привет", command: "", args: ""},
+	}
+
+	for _, tc := range testCases {
+		cmd, args := b.findAlias(tc.input)
+		name := ""
+		if cmd != nil {
+			name = cmd.name
+		}
+		if name != tc.command || args != tc.args {
+			t.Errorf("findAlias(%q) = %q, %q; expected %q, %q", tc.input, name, args, tc.command, tc.args)
+		}
+	}
+}
+
+func TestMemoryCommands(t *testing.T) {
+	b := &bot{}
+	chat := NewChatContext(1, 10)
+
+	if _, err := b.cmdRemember(nil, chat, ""); !errors.Is(err, errUsage) {
+		t.Errorf("expected usage error, got %v", err)
+	}
+
+	if rs, err := b.cmdRemember(nil, chat, "кот любит рыбу"); err != nil || rs != "Теперь я помню: кот любит рыбу" {
+		t.Errorf("cmdRemember = %q, %v", rs, err)
+	}
+
+	if rs, err := b.cmdMemory(nil, chat, ""); err != nil || rs != "Меня просили запомнить:\n1. кот любит рыбу\n" {
+		t.Errorf("cmdMemory = %q, %v", rs, err)
+	}
+
+	if _, err := b.cmdForget(nil, chat, "two"); !errors.Is(err, errUsage) {
+		t.Errorf("expected usage error, got %v", err)
+	}
+
+	if _, err := b.cmdForget(nil, chat, "2"); err == nil {
+		t.Errorf("expected error for missing fact")
+	}
+
+	if rs, err := b.cmdForget(nil, chat, "1"); err != nil || rs != "Забыл: кот любит рыбу" {
+		t.Errorf("cmdForget = %q, %v", rs, err)
+	}
+}
+
+func TestResetCommand(t *testing.T) {
+	b := &bot{}
+	chat := NewChatContext(1, 10)
+	chat.History.Add(Message{UserType: UserTypeUser, Message: "hello"})
+
+	if _, err := b.cmdReset(nil, chat, ""); err != nil {
+		t.Fatalf("cmdReset: %v", err)
+	}
+
+	if history := chat.History.GetAll(); len(history) != 0 {
+		t.Errorf("expected empty history, got %+v", history)
+	}
+}
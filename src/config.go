@@ -1,47 +1,443 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	BotToken          string   `json:"botToken"`
-	Model             string   `json:"model"`
-	ServerURL         string   `json:"serverUrl"`
-	EnableLog         bool     `json:"enableLog"`
-	ChatGroupID       int64    `json:"chatGroupId"`
-	SystemPrompt      string   `json:"systemPrompt"`
-	Temperature       float64  `json:"temperature"`
-	NumCtx            int      `json:"numCtx"`
-	GreetingMessage   string   `json:"greetingMessage"`
-	GoodbyeMessage    string   `json:"goodbyeMessage"`
-	TriggerWords      []string `json:"triggerWords"`
-	RemoveFromReplay  string   `json:"removeFromReplay"`
-	HistorySize       int      `json:"historySize"`
-	EnableSaveHistory bool     `json:"enableSaveHistory"`
-	GiphyAPIKey      string    `json:"giphyAPIKey"`
+	BotToken           string   `json:"botToken"`
+	Model              string   `json:"model"`
+	ServerURL          string   `json:"serverUrl"`
+	FallbackServers    []string `json:"fallbackServers"` // tried in order when serverUrl fails
+	FallbackModels     []string `json:"fallbackModels"`  // tried in order when the model fails on every server
+	BreakerThreshold   int      `json:"breakerThreshold"`
+	BreakerCooldown    int      `json:"breakerCooldown"` // seconds
+	EnableLog          bool     `json:"enableLog"`       // debug level when logLevel is not set
+	LogLevel           string   `json:"logLevel"`        // debug, info, warn or error
+	LogFormat          string   `json:"logFormat"`       // text or json
+	LogFile            string   `json:"logFile"`         // stderr when empty
+	LogMaxSize         int      `json:"logMaxSize"`      // megabytes before the file is rotated
+	LogMaxBackups      int      `json:"logMaxBackups"`
+	LogMaxAge          int      `json:"logMaxAge"` // days
+	LogCompress        bool     `json:"logCompress"`
+	LogRedactMessages  bool     `json:"logRedactMessages"` // hide texts of messages and LLM requests
+	AllowedChats       []int64  `json:"allowedChats"`
+	AllowedUsers       []int64  `json:"allowedUsers"`
+	Owners             []int64  `json:"owners"`
+	SystemPrompt       string   `json:"systemPrompt"`
+	Temperature        float64  `json:"temperature"`
+	NumCtx             int      `json:"numCtx"`
+	ContextReserve     int      `json:"contextReserve"` // tokens of num_ctx left for the answer
+	GreetingMessage    string   `json:"greetingMessage"`
+	GoodbyeMessage     string   `json:"goodbyeMessage"`
+	BacklogPolicy      string   `json:"backlogPolicy"` // what to do with messages sent while the bot was offline
+	TriggerWords       []string `json:"triggerWords"`
+	RemoveFromReplay   string   `json:"removeFromReplay"`
+	HistorySize        int      `json:"historySize"`
+	EnableSaveHistory  bool     `json:"enableSaveHistory"`
+	HistoryDir         string   `json:"historyDir"`
+	Storage            string   `json:"storage"`
+	StoragePath        string   `json:"storagePath"`
+	SaveInterval       int      `json:"saveInterval"`
+	EnableSummary      bool     `json:"enableSummary"`
+	SummaryBatchSize   int      `json:"summaryBatchSize"`
+	SummaryModel       string   `json:"summaryModel"`
+	EmbeddingModel     string   `json:"embeddingModel"` // needs enableSaveHistory, the vector index is saved with the histories
+	MemoryTopK         int      `json:"memoryTopK"`
+	ArchiveHistory     bool     `json:"archiveHistory"`
+	GiphyAPIKey        string   `json:"giphyAPIKey"`
+	EnableStream       bool     `json:"enableStream"`
+	StreamEditInterval int      `json:"streamEditInterval"` // milliseconds between edits of a streamed message
+	EnableTools        bool     `json:"enableTools"`
+	MaxToolIterations  int      `json:"maxToolIterations"`
+	EnableVision       bool     `json:"enableVision"`
+	MaxImageBytes      int      `json:"maxImageBytes"`
+	MaxImageSize       int      `json:"maxImageSize"` // pixels on the longest side
+	Workers            int      `json:"workers"`
+	QueueSize          int      `json:"queueSize"`
+	RequestTimeout     int      `json:"requestTimeout"` // seconds
+	Retries            int      `json:"retries"`
+	RetryBackoff       int      `json:"retryBackoff"` // milliseconds
+	QueueFullMessage   string   `json:"queueFullMessage"`
+	ShutdownTimeout    int      `json:"shutdownTimeout"` // seconds to finish queued requests on shutdown
+	ShutdownMessage    string   `json:"shutdownMessage"`
+	UserRateLimit      int      `json:"userRateLimit"`
+	ChatRateLimit      int      `json:"chatRateLimit"`
+	RateLimitWindow    int      `json:"rateLimitWindow"`
+	RateLimitMessage   string   `json:"rateLimitMessage"`
+	HTTPListen         string   `json:"httpListen"`
+	WebhookURL         string   `json:"webhookUrl"` // public https url telegram posts updates to, long polling is used when empty
+	WebhookListen      string   `json:"webhookListen"`
+	WebhookSecret      string   `json:"webhookSecret"` // checked in every webhook request
+	WebhookCert        string   `json:"webhookCert"`   // TLS for the webhook listener, not needed behind a TLS proxy
+	WebhookKey         string   `json:"webhookKey"`
+	WebhookSelfSigned  bool     `json:"webhookSelfSigned"` // upload webhookCert to telegram
+
+	Providers       map[string]ProviderConfig `json:"providers"`
+	ChatProviders   map[int64]string          `json:"chatProviders"` // chat id to provider name
+	DefaultProvider string                    `json:"defaultProvider"`
 }
 
-func loadConfig(filename string) (*Config, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, fmt.Errorf("Config open error: %v", err)
+// ProviderConfig describes an LLM backend, the "ollama" provider from serverUrl always exists
+type ProviderConfig struct {
+	Type           string   `json:"type"` // ollama or openai
+	URL            string   `json:"url"`
+	FallbackURLs   []string `json:"fallbackUrls"`
+	APIKey         string   `json:"apiKey"`
+	Model          string   `json:"model"` // used when the chat has no model set
+	FallbackModels []string `json:"fallbackModels"`
+	Vision         bool     `json:"vision"` // openai servers don't report model capabilities
+}
+
+// defaultConfig returns the values used for keys missing in the config file
+func defaultConfig() *Config {
+	return &Config{
+		ServerURL:          "http://localhost:11434/",
+		HistorySize:        50,
+		HistoryDir:         "./",
+		BacklogPolicy:      backlogHistory,
+		Storage:            "file",
+		SaveInterval:       int(defaultSaveInterval / time.Second),
+		SummaryBatchSize:   defaultSummaryBatchSize,
+		MemoryTopK:         defaultMemoryTopK,
+		StreamEditInterval: int(defaultStreamEditInterval / time.Millisecond),
+		MaxToolIterations:  defaultMaxToolIterations,
+		MaxImageBytes:      defaultMaxImageBytes,
+		MaxImageSize:       defaultMaxImageSize,
+		Workers:            defaultWorkers,
+		QueueSize:          defaultQueueSize,
+		RequestTimeout:     int(defaultRequestTimeout / time.Second),
+		ShutdownTimeout:    int(defaultShutdownTimeout / time.Second),
+		Retries:            2,
+		RetryBackoff:       500,
+		RateLimitWindow:    60,
+		BreakerThreshold:   defaultBreakerThreshold,
+		BreakerCooldown:    int(defaultBreakerCooldown / time.Second),
+		LogMaxSize:         100,
+		WebhookListen:      ":8443",
 	}
-	defer file.Close()
+}
 
-	config := &Config{}
-	bytes, err := io.ReadAll(file)
+// loadConfig reads the config file, applies environment overrides and validates the result.
+// The format is chosen by the file extension: .json, .yaml, .yml or .toml
+func loadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("Config read error: %v", err)
 	}
 
-	err = json.Unmarshal(bytes, config)
-	if err != nil {
+	config := defaultConfig()
+	if err := decodeConfig(filename, data, config); err != nil {
 		return nil, fmt.Errorf("Config parse error: %v", err)
 	}
 
+	if err := applyEnv(config, os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("Config env error: %v", err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("Config validation error: %v", err)
+	}
+
 	return config, nil
 }
+
+// decodeConfig decodes the file strictly, unknown keys are errors. YAML and TOML are converted
+// to JSON first, so all formats use the same keys
+func decodeConfig(filename string, data []byte, config *Config) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var raw any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		converted, err := json.Marshal(jsonCompatible(raw))
+		if err != nil {
+			return err
+		}
+		data = converted
+	case ".toml":
+		var raw map[string]any
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		converted, err := json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		data = converted
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after the config object")
+	}
+	return nil
+}
+
+// jsonCompatible converts YAML maps with non string keys, like chat ids, to JSON objects
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = jsonCompatible(value)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case []any:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+		return v
+	}
+	return v
+}
+
+// envName returns the environment variable of a config key: botToken is BOT_TOKEN, giphyAPIKey is GIPHY_API_KEY
+func envName(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower && unicode.IsUpper(runes[i-1]) {
+				b.WriteByte('_')
+			}
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			r = '_'
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// applyEnv overrides config values with environment variables named after the keys.
+// Lists are comma separated, api keys of providers are read from <NAME>_API_KEY
+func applyEnv(config *Config, lookup func(string) (string, bool)) error {
+	var errs []error
+
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := envName(key)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	for name, pc := range config.Providers {
+		if value, ok := lookup(envName(name) + "_API_KEY"); ok {
+			pc.APIKey = value
+			config.Providers[name] = pc
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFromEnv(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("can't be set from the environment")
+	}
+	return nil
+}
+
+// validate checks values that would make the bot fail later, all problems are reported at once
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.BotToken != "", "botToken is required, set it in the file or with BOT_TOKEN")
+	check(c.Model != "", "model is required")
+	check(validURL(c.ServerURL), "serverUrl %q is not a valid http url", c.ServerURL)
+	for _, u := range c.FallbackServers {
+		check(validURL(u), "fallbackServers: %q is not a valid http url", u)
+	}
+	check(c.HistorySize > 0, "historySize must be positive")
+	check(c.Temperature >= 0 && c.Temperature <= 2, "temperature must be between 0 and 2")
+	check(c.NumCtx >= 0, "numCtx must not be negative")
+	check(c.NumCtx == 0 || c.ContextReserve < c.NumCtx, "contextReserve must be less than numCtx")
+	check(slices.Contains([]string{"", "file", "bolt"}, c.Storage), "storage must be file or bolt, got %q", c.Storage)
+	check(slices.Contains([]string{"", backlogIgnore, backlogHistory, backlogAnswerLatest}, c.BacklogPolicy), "backlogPolicy must be ignore, history or answer-latest, got %q", c.BacklogPolicy)
+	check(!c.ArchiveHistory || c.EmbeddingModel != "", "archiveHistory requires embeddingModel")
+	// The vector index is kept in the history storage, without it every restart embeds all memory again
+	check(c.EmbeddingModel == "" || c.EnableSaveHistory, "embeddingModel requires enableSaveHistory")
+	// With 0 queued requests would be cancelled at once
+	check(c.ShutdownTimeout >= 1, "shutdownTimeout must be at least 1")
+
+	for name, value := range map[string]int{
+		"workers":          c.Workers,
+		"queueSize":        c.QueueSize,
+		"requestTimeout":   c.RequestTimeout,
+		"retries":          c.Retries,
+		"retryBackoff":     c.RetryBackoff,
+		"userRateLimit":    c.UserRateLimit,
+		"chatRateLimit":    c.ChatRateLimit,
+		"rateLimitWindow":  c.RateLimitWindow,
+		"saveInterval":     c.SaveInterval,
+		"summaryBatchSize": c.SummaryBatchSize,
+	} {
+		check(value >= 0, "%s must not be negative", name)
+	}
+
+	if _, err := parseLogLevel(c); err != nil {
+		errs = append(errs, err)
+	}
+	check(slices.Contains([]string{"", "text", "json"}, strings.ToLower(c.LogFormat)), "logFormat must be text or json, got %q", c.LogFormat)
+
+	if c.WebhookURL != "" {
+		check(strings.HasPrefix(c.WebhookURL, "https://") && validURL(c.WebhookURL), "webhookUrl %q must be a https url", c.WebhookURL)
+		check(c.WebhookListen != "", "webhookListen is required for the webhook")
+		check(validSecretToken(c.WebhookSecret), "webhookSecret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+		check((c.WebhookCert == "") == (c.WebhookKey == ""), "webhookCert and webhookKey must be set together")
+		check(!c.WebhookSelfSigned || c.WebhookCert != "", "webhookSelfSigned requires webhookCert")
+	}
+
+	for name, pc := range c.Providers {
+		check(slices.Contains([]string{"", providerOllama, providerOpenAI}, pc.Type), "provider %s: unknown type %q", name, pc.Type)
+		check(validURL(pc.URL), "provider %s: url %q is not a valid http url", name, pc.URL)
+		for _, u := range pc.FallbackURLs {
+			check(validURL(u), "provider %s: fallback url %q is not a valid http url", name, u)
+		}
+	}
+	for chatID, name := range c.ChatProviders {
+		check(c.hasProvider(name), "chatProviders: chat %d uses unknown provider %s", chatID, name)
+	}
+	check(c.DefaultProvider == "" || c.hasProvider(c.DefaultProvider), "defaultProvider: unknown provider %s", c.DefaultProvider)
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) hasProvider(name string) bool {
+	_, ok := c.Providers[name]
+	return ok || name == providerOllama
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validSecretToken checks the characters telegram allows in a webhook secret token
+func validSecretToken(s string) bool {
+	if len(s) == 0 || len(s) > 256 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// secrets returns the values that must never get into logs
+func (c *Config) secrets() []string {
+	secrets := []string{c.BotToken, c.GiphyAPIKey, c.WebhookSecret}
+	for _, pc := range c.Providers {
+		secrets = append(secrets, pc.APIKey)
+	}
+	return secrets
+}
+
+// LogValue logs the config without secrets
+func (c *Config) LogValue() slog.Value {
+	// plain has no LogValue method, so it is not resolved again
+	type plain Config
+	return slog.AnyValue(plain(*c.redacted()))
+}
+
+// redacted returns a copy of the config with secrets replaced
+func (c *Config) redacted() *Config {
+	safe := *c
+	if safe.BotToken != "" {
+		safe.BotToken = redacted
+	}
+	if safe.GiphyAPIKey != "" {
+		safe.GiphyAPIKey = redacted
+	}
+	if safe.WebhookSecret != "" {
+		safe.WebhookSecret = redacted
+	}
+	safe.Providers = make(map[string]ProviderConfig, len(c.Providers))
+	for name, pc := range c.Providers {
+		if pc.APIKey != "" {
+			pc.APIKey = redacted
+		}
+		safe.Providers[name] = pc
+	}
+	return &safe
+}
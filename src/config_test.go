@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	config, err := loadConfig("../config.json")
+	if err != nil {
+		t.Fatalf("example config must be valid: %v", err)
+	}
+	if config.NumCtx != 2048 {
+		t.Errorf("expected numCtx from the example, got %d", config.NumCtx)
+	}
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"botToken": "t", "model": "llama", "mumCtx": 2048}`)
+
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "mumCtx") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"botToken": "t", "model": "llama", "workers": 4}`)
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if config.Workers != 4 || config.QueueSize != defaultQueueSize || config.HistorySize != 50 || config.ServerURL == "" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	path := writeConfig(t, "config.json", `{
+		"serverUrl": "localhost:11434",
+		"temperature": 3,
+		"storage": "redis",
+		"backlogPolicy": "answer-all",
+		"workers": -1,
+		"shutdownTimeout": 0,
+		"embeddingModel": "nomic-embed-text",
+		"chatProviders": {"1": "missing"}
+	}`)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"botToken", "model", "serverUrl", "temperature", "storage", "backlogPolicy", "workers", "shutdownTimeout", "embeddingModel requires enableSaveHistory", "missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error must mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigWebhook(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"botToken": "t", "model": "llama", "webhookUrl": "http://bot.example.com", "webhookSecret": "bad secret", "webhookCert": "cert.pem"}`)
+
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"webhookUrl", "webhookSecret", "webhookKey"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error must mention %s: %v", want, err)
+		}
+	}
+
+	path = writeConfig(t, "config.json", `{"botToken": "t", "model": "llama", "webhookUrl": "https://bot.example.com/telegram", "webhookSecret": "s3cret-token"}`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if config.WebhookListen != ":8443" {
+		t.Errorf("expected default webhookListen, got %q", config.WebhookListen)
+	}
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	t.Setenv("BOT_TOKEN", "from-env")
+	t.Setenv("ALLOWED_CHATS", "-100, 42")
+	t.Setenv("ENABLE_STREAM", "true")
+	t.Setenv("GIPHY_API_KEY", "giphy")
+	t.Setenv("LLAMACPP_API_KEY", "sk-env")
+
+	path := writeConfig(t, "config.json", `{"model": "llama", "providers": {"llamacpp": {"type": "openai", "url": "http://localhost:8080/v1"}}}`)
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if config.BotToken != "from-env" || !config.EnableStream || config.GiphyAPIKey != "giphy" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if !slices.Equal(config.AllowedChats, []int64{-100, 42}) {
+		t.Errorf("unexpected allowed chats %v", config.AllowedChats)
+	}
+	if config.Providers["llamacpp"].APIKey != "sk-env" {
+		t.Errorf("provider api key must come from the environment")
+	}
+
+	t.Setenv("WORKERS", "many")
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "WORKERS") {
+		t.Errorf("expected env error, got %v", err)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	yamlPath := writeConfig(t, "config.yaml", `
+botToken: t
+model: llama
+triggerWords: ["@bot", "assistant"]
+providers:
+  vllm:
+    type: openai
+    url: http://localhost:8000/v1
+chatProviders:
+  -100: vllm
+`)
+	tomlPath := writeConfig(t, "config.toml", `
+botToken = "t"
+model = "llama"
+triggerWords = ["@bot", "assistant"]
+
+[providers.vllm]
+type = "openai"
+url = "http://localhost:8000/v1"
+
+[chatProviders]
+"-100" = "vllm"
+`)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		config, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(config.TriggerWords) != 2 || config.ChatProviders[-100] != "vllm" || config.Providers["vllm"].Type != "openai" {
+			t.Errorf("%s: unexpected config %+v", path, config)
+		}
+	}
+
+	badPath := writeConfig(t, "bad.yaml", "botToken: t\nmodel: llama\nunknown: 1\n")
+	if _, err := loadConfig(badPath); err == nil {
+		t.Errorf("expected unknown key error for yaml")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"botToken":    "BOT_TOKEN",
+		"giphyAPIKey": "GIPHY_API_KEY",
+		"httpListen":  "HTTP_LISTEN",
+		"numCtx":      "NUM_CTX",
+		"model":       "MODEL",
+		"llama-cpp":   "LLAMA_CPP",
+	}
+	for key, want := range tests {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestMergeReload(t *testing.T) {
+	current := &Config{BotToken: "t", Model: "llama", SystemPrompt: "old", Workers: 2}
+	loaded := &Config{BotToken: "t", Model: "qwen", SystemPrompt: "new", TriggerWords: []string{"bot"}, Workers: 8}
+
+	merged, skipped := mergeReload(current, loaded)
+
+	if merged.SystemPrompt != "new" || merged.Model != "qwen" || len(merged.TriggerWords) != 1 {
+		t.Errorf("reloadable fields must change: %+v", merged)
+	}
+	if merged.Workers != 2 || !slices.Equal(skipped, []string{"Workers"}) {
+		t.Errorf("structural fields must stay, got workers %d, skipped %v", merged.Workers, skipped)
+	}
+	if current.SystemPrompt != "old" {
+		t.Errorf("current config must not be changed")
+	}
+
+	b := &bot{config: current}
+	b.liveConfig.Store(merged)
+	if b.cfg().SystemPrompt != "new" {
+		t.Errorf("cfg must return the reloaded config")
+	}
+}
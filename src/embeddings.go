@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	defaultMemoryTopK = 5
+	embedTimeout      = 30 * time.Second
+	reindexTimeout    = 10 * time.Minute
+	embedBatchSize    = 32
+
+	vectorSourceMemory  = "memory"
+	vectorSourceHistory = "history"
+)
+
+var (
+	errEmbeddingsDisabled = errors.New("embeddings are disabled, set embeddingModel in the config")
+	errStaleIndex         = errors.New("index was built with another embedding model, run /reindex")
+)
+
+// VectorEntry is an embedded memory fact or archived history message
+type VectorEntry struct {
+	Source string    `json:"source"`
+	Role   UserType  `json:"role,omitempty"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+type VectorMatch struct {
+	VectorEntry
+	Score float32
+}
+
+// VectorIndex keeps embeddings of one chat. Vectors are normalized, so the dot product is the cosine similarity
+type VectorIndex struct {
+	mu       sync.Mutex    `json:"-"`
+	Model    string        `json:"model"`
+	Entries  []VectorEntry `json:"entries"`
+	onChange func()        `json:"-"`
+}
+
+// Len returns the number of indexed entries
+func (vi *VectorIndex) Len() int {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	return len(vi.Entries)
+}
+
+// Stale reports whether the index was built with another embedding model
+func (vi *VectorIndex) Stale(model string) bool {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	return len(vi.Entries) > 0 && vi.Model != model
+}
+
+// Add appends entries embedded with model, they are dropped if the index uses another model
+func (vi *VectorIndex) Add(model string, entries ...VectorEntry) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	if len(vi.Entries) > 0 && vi.Model != model {
+		return
+	}
+
+	vi.Model = model
+	vi.Entries = append(vi.Entries, entries...)
+	notify(vi.onChange)
+}
+
+// Replace swaps the whole index, used by reindexing
+func (vi *VectorIndex) Replace(model string, entries []VectorEntry) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.Model = model
+	vi.Entries = entries
+	notify(vi.onChange)
+}
+
+// ClearHistory removes archived messages, memory facts are kept
+func (vi *VectorIndex) ClearHistory() {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	vi.Entries = slices.DeleteFunc(vi.Entries, func(e VectorEntry) bool {
+		return e.Source == vectorSourceHistory
+	})
+	notify(vi.onChange)
+}
+
+// syncMemory drops entries of removed memory facts and returns facts that are not indexed yet
+func (vi *VectorIndex) syncMemory(memory []string) []string {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	indexed := make(map[string]bool)
+	n := len(vi.Entries)
+	vi.Entries = slices.DeleteFunc(vi.Entries, func(e VectorEntry) bool {
+		if e.Source != vectorSourceMemory {
+			return false
+		}
+		if !slices.Contains(memory, e.Text) || indexed[e.Text] {
+			return true
+		}
+		indexed[e.Text] = true
+		return false
+	})
+	if len(vi.Entries) != n {
+		notify(vi.onChange)
+	}
+
+	var missing []string
+	for _, text := range memory {
+		if !indexed[text] {
+			indexed[text] = true
+			missing = append(missing, text)
+		}
+	}
+
+	return missing
+}
+
+// Search returns up to k entries most similar to the vector, best first
+func (vi *VectorIndex) Search(vector []float32, k int) []VectorMatch {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	matches := make([]VectorMatch, 0, len(vi.Entries))
+	for _, e := range vi.Entries {
+		if len(e.Vector) != len(vector) {
+			continue
+		}
+		matches = append(matches, VectorMatch{VectorEntry: e, Score: dot(e.Vector, vector)})
+	}
+
+	slices.SortStableFunc(matches, func(a, b VectorMatch) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+
+	return matches[:min(k, len(matches))]
+}
+
+func (vi *VectorIndex) entries() []VectorEntry {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	return slices.Clone(vi.Entries)
+}
+
+func (vi *VectorIndex) marshal() ([]byte, error) {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+
+	return json.Marshal(vi)
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// embed returns normalized embeddings of the texts, requested in batches
+func (b *bot) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+
+	for batch := range slices.Chunk(texts, embedBatchSize) {
+		resp, err := b.ollama.Embed(ctx, &ollama.EmbedRequest{
+			Model: b.cfg().EmbeddingModel,
+			Input: batch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Embeddings) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(resp.Embeddings), len(batch))
+		}
+
+		for _, v := range resp.Embeddings {
+			vectors = append(vectors, normalize(v))
+		}
+	}
+
+	return vectors, nil
+}
+
+func (b *bot) memoryTopK() int {
+	if b.cfg().MemoryTopK > 0 {
+		return b.cfg().MemoryTopK
+	}
+	return defaultMemoryTopK
+}
+
+// recall indexes new memory facts and returns the entries most relevant to the query
+func (b *bot) recall(ctx context.Context, chat *ChatContext, query string, k int) ([]VectorMatch, error) {
+	model := b.cfg().EmbeddingModel
+	if model == "" {
+		return nil, errEmbeddingsDisabled
+	}
+	if chat.Vectors.Stale(model) {
+		return nil, errStaleIndex
+	}
+
+	missing := chat.Vectors.syncMemory(chat.Memory.GetAll())
+
+	// The query and new facts are embedded with one request
+	vectors, err := b.embed(ctx, append([]string{query}, missing...))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]VectorEntry, len(missing))
+	for i, text := range missing {
+		entries[i] = VectorEntry{Source: vectorSourceMemory, Text: text, Vector: vectors[i+1]}
+	}
+	chat.Vectors.Add(model, entries...)
+
+	return chat.Vectors.Search(vectors[0], k), nil
+}
+
+// recallPrompt returns memory facts and archived messages relevant to the query for the system prompt.
+// The whole memory is used when the index can't be searched
+func (b *bot) recallPrompt(ctx context.Context, chat *ChatContext, query string) string {
+	fallback := ""
+	if len(chat.Memory.GetAll()) > 0 {
+		fallback = fmt.Sprintf("\nТебя просили запомнить:\n%s", chat.Memory.GetList())
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	matches, err := b.recall(ctx, chat, query, b.memoryTopK())
+	if err != nil {
+		logLLM.Error("Error searching memory", "chat", chat.Chat, "error", err)
+		return fallback
+	}
+
+	var memory, history strings.Builder
+	for _, m := range matches {
+		if m.Source == vectorSourceMemory {
+			fmt.Fprintf(&memory, "- %s\n", m.Text)
+		} else {
+			fmt.Fprintf(&history, "%s: %s\n", m.Role, m.Text)
+		}
+	}
+
+	rs := ""
+	if memory.Len() > 0 {
+		rs += "\nТебя просили запомнить:\n" + memory.String()
+	}
+	if history.Len() > 0 {
+		rs += "\nИз прошлых разговоров:\n" + history.String()
+	}
+
+	return rs
+}
+
+// archiveMessage adds a message dropped from the history to the vector index
+func (b *bot) archiveMessage(chat *ChatContext, msg Message) {
+	if msg.UserType != UserTypeUser && msg.UserType != UserTypeAI || strings.TrimSpace(msg.Message) == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+
+	vectors, err := b.embed(ctx, []string{msg.Message})
+	if err != nil {
+		logLLM.Error("Error archiving message", "chat", chat.Chat, "error", err)
+		return
+	}
+
+	chat.Vectors.Add(b.cfg().EmbeddingModel, VectorEntry{
+		Source: vectorSourceHistory,
+		Role:   msg.UserType,
+		Text:   msg.Message,
+		Vector: vectors[0],
+	})
+}
+
+// reindex embeds memory facts and archived messages of the chat again with the current embedding model
+func (b *bot) reindex(ctx context.Context, chat *ChatContext) (int, error) {
+	if b.cfg().EmbeddingModel == "" {
+		return 0, errEmbeddingsDisabled
+	}
+
+	var entries []VectorEntry
+	for _, text := range chat.Memory.GetAll() {
+		entries = append(entries, VectorEntry{Source: vectorSourceMemory, Text: text})
+	}
+	for _, e := range chat.Vectors.entries() {
+		if e.Source == vectorSourceHistory {
+			entries = append(entries, VectorEntry{Source: e.Source, Role: e.Role, Text: e.Text})
+		}
+	}
+
+	texts := make([]string, len(entries))
+	for i, e := range entries {
+		texts[i] = e.Text
+	}
+
+	vectors, err := b.embed(ctx, texts)
+	if err != nil {
+		return 0, err
+	}
+	for i := range entries {
+		entries[i].Vector = vectors[i]
+	}
+
+	chat.Vectors.Replace(b.cfg().EmbeddingModel, entries)
+	return len(entries), nil
+}
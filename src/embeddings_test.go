@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+// newEmbedServer embeds texts by the animals they mention
+func newEmbedServer(t *testing.T, calls *int) *ollama.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollama.EmbedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		*calls++
+
+		resp := ollama.EmbedResponse{Model: req.Model}
+		for _, text := range req.Input {
+			v := []float32{0.1, 0.1, 0.1}
+			for i, word := range []string{"cat", "dog", "fish"} {
+				if strings.Contains(text, word) {
+					v[i] = 1
+				}
+			}
+			resp.Embeddings = append(resp.Embeddings, v)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := ollama.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRecallPrompt(t *testing.T) {
+	calls := 0
+	b := &bot{config: &Config{EmbeddingModel: "embed", MemoryTopK: 1}, ollama: newEmbedServer(t, &calls)}
+
+	chat := NewChatContext(1, 10)
+	chat.Memory.Add("my cat is Tom")
+	chat.Memory.Add("my dog is Rex")
+	chat.Memory.Add("my fish is Nemo")
+
+	rs := b.recallPrompt(context.Background(), chat, "how is the dog?")
+	if !strings.Contains(rs, "Rex") || strings.Contains(rs, "Tom") || strings.Contains(rs, "Nemo") {
+		t.Errorf("unexpected prompt %q", rs)
+	}
+	if chat.Vectors.Len() != 3 {
+		t.Errorf("expected 3 indexed facts, got %d", chat.Vectors.Len())
+	}
+
+	chat.Memory.Remove(1)
+	if matches, err := b.recall(context.Background(), chat, "dog", 3); err != nil || len(matches) != 2 {
+		t.Errorf("expected removed fact to leave the index, got %+v %v", matches, err)
+	}
+}
+
+func TestRecallPromptFallsBackToMemory(t *testing.T) {
+	calls := 0
+	b := &bot{config: &Config{EmbeddingModel: "embed", MemoryTopK: 1}, ollama: newEmbedServer(t, &calls)}
+
+	chat := NewChatContext(1, 10)
+	chat.Memory.Add("my cat is Tom")
+	chat.Memory.Add("my dog is Rex")
+	chat.Vectors.Add("old-model", VectorEntry{Source: vectorSourceMemory, Text: "my cat is Tom", Vector: []float32{1}})
+
+	if rs := b.recallPrompt(context.Background(), chat, "dog"); !strings.Contains(rs, "Tom") || !strings.Contains(rs, "Rex") {
+		t.Errorf("expected the whole memory with a stale index, got %q", rs)
+	}
+	if calls != 0 {
+		t.Errorf("expected no embed requests, got %d", calls)
+	}
+}
+
+func TestRecallPromptUsesRequestContext(t *testing.T) {
+	calls := 0
+	b := &bot{config: &Config{EmbeddingModel: "embed", MemoryTopK: 1}, ollama: newEmbedServer(t, &calls)}
+
+	chat := NewChatContext(1, 10)
+	chat.Memory.Add("my cat is Tom")
+	chat.Memory.Add("my dog is Rex")
+
+	// A cancelled request does not wait for the embed model
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if rs := b.recallPrompt(ctx, chat, "dog"); !strings.Contains(rs, "Tom") || !strings.Contains(rs, "Rex") {
+		t.Errorf("expected the whole memory when the request is cancelled, got %q", rs)
+	}
+}
+
+func TestArchiveAndReindex(t *testing.T) {
+	calls := 0
+	b := &bot{config: &Config{EmbeddingModel: "embed"}, ollama: newEmbedServer(t, &calls)}
+
+	chat := NewChatContext(1, 10)
+	chat.Memory.Add("my cat is Tom")
+	b.archiveMessage(chat, Message{UserType: UserTypeUser, Message: "the fish is hungry"})
+	b.archiveMessage(chat, Message{UserType: UserTypeTool, Message: "tool output"})
+
+	b.config.EmbeddingModel = "embed-v2"
+	if !chat.Vectors.Stale("embed-v2") {
+		t.Fatal("expected the index to be stale after changing the model")
+	}
+
+	n, err := b.reindex(context.Background(), chat)
+	if err != nil {
+		t.Fatalf("reindex: %v", err)
+	}
+	if n != 2 || chat.Vectors.Stale("embed-v2") {
+		t.Errorf("unexpected reindex result %d, model %s", n, chat.Vectors.Model)
+	}
+
+	matches, err := b.recall(context.Background(), chat, "fish", 1)
+	if err != nil {
+		t.Fatalf("recall: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Source != vectorSourceHistory || matches[0].Role != UserTypeUser {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+var errNoBackends = errors.New("all backends are unavailable")
+
+// breaker is a circuit breaker of one endpoint. It opens after threshold failures in a row
+// and lets one trial request through when the cooldown passes
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool // a trial request is running, others are rejected until it ends
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Allow reports whether a request may be sent to the endpoint
+func (br *breaker) Allow() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if br.failures < br.threshold {
+		return true
+	}
+	if br.probing || br.now().Before(br.openUntil) {
+		return false
+	}
+	br.probing = true
+	return true
+}
+
+// Open reports whether the endpoint is skipped, unlike Allow it does not start a trial.
+// It is used by lookups that neither open nor close the breaker
+func (br *breaker) Open() bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	return br.failures >= br.threshold && (br.probing || br.now().Before(br.openUntil))
+}
+
+// Success closes the breaker
+func (br *breaker) Success() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.failures = 0
+	br.probing = false
+}
+
+// Release ends a trial request whose error says nothing about the endpoint, the next request is a trial again
+func (br *breaker) Release() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.probing = false
+}
+
+// Failure counts a failure, the breaker opens for the cooldown when there are enough of them
+func (br *breaker) Failure() {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.failures++
+	br.probing = false
+	if br.failures >= br.threshold {
+		br.openUntil = br.now().Add(br.cooldown)
+	}
+}
+
+// endpoint is one server of a provider
+type endpoint struct {
+	url      string
+	provider Provider
+	breaker  *breaker
+}
+
+// failoverProvider tries the request model on every endpoint in order, then the fallback models.
+// Endpoints that keep failing are skipped until their breaker cooldown passes
+type failoverProvider struct {
+	name      string
+	endpoints []*endpoint
+	models    []string // fallback models
+	ollama    bool     // all endpoints are ollama servers
+}
+
+// failoverError reports whether another endpoint may answer and whether the endpoint itself failed
+func failoverError(err error) (next, failed bool) {
+	switch {
+	case errors.Is(err, ollama.ErrContextOverflow), errors.Is(err, context.Canceled):
+		return false, false
+	case errors.Is(err, ollama.ErrModelNotFound):
+		return true, false
+	case errors.Is(err, ollama.ErrConnection), errors.Is(err, ollama.ErrTimeout), errors.Is(err, ollama.ErrServer):
+		return true, true
+	}
+	return false, false
+}
+
+// try sends the request to the endpoints in order until one answers.
+// Once started returns true the answer is partly sent and failures are returned as is
+func (p *failoverProvider) try(ctx context.Context, req *ollama.ChatRequest, started func() bool, call func(Provider, *ollama.ChatRequest) (*ollama.ChatResponse, error)) (*ollama.ChatResponse, error) {
+	models := []string{req.Model}
+	for _, model := range p.models {
+		if !slices.Contains(models, model) {
+			models = append(models, model)
+		}
+	}
+
+	var lastErr error
+	for _, model := range models {
+		r := *req
+		r.Model = model
+
+		for _, ep := range p.endpoints {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !ep.breaker.Allow() {
+				continue
+			}
+
+			resp, err := call(ep.provider, &r)
+			if err == nil {
+				ep.breaker.Success()
+				metricBackendAnswers.WithLabelValues(p.name, ep.url, model).Inc()
+				if model != req.Model || ep != p.endpoints[0] {
+					logLLM.Warn("Request answered by fallback backend", "provider", p.name, "endpoint", ep.url, "model", model)
+				}
+				return resp, nil
+			}
+
+			// The request deadline says nothing about the endpoint, no other endpoint gets time either
+			if ctx.Err() != nil {
+				ep.breaker.Release()
+				return nil, err
+			}
+
+			next, failed := failoverError(err)
+			if failed {
+				ep.breaker.Failure()
+				metricBackendFailures.WithLabelValues(p.name, ep.url).Inc()
+			} else {
+				ep.breaker.Release()
+			}
+			if !next || started != nil && started() {
+				return nil, err
+			}
+
+			logLLM.Warn("Backend failed", "provider", p.name, "endpoint", ep.url, "model", model, "error", err)
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		return nil, fmt.Errorf("provider %s: %w", p.name, &noBackendsError{})
+	}
+	return nil, lastErr
+}
+
+func (p *failoverProvider) Chat(ctx context.Context, req *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+	return p.try(ctx, req, nil, func(provider Provider, r *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+		return provider.Chat(ctx, r)
+	})
+}
+
+// ChatStream fails over only until the first chunk, a broken stream can't be continued by another server
+func (p *failoverProvider) ChatStream(ctx context.Context, req *ollama.ChatRequest, fn func(*ollama.ChatResponse) error) (*ollama.ChatResponse, error) {
+	var started bool
+	return p.try(ctx, req, func() bool { return started }, func(provider Provider, r *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+		return provider.ChatStream(ctx, r, func(chunk *ollama.ChatResponse) error {
+			started = true
+			if fn == nil {
+				return nil
+			}
+			return fn(chunk)
+		})
+	})
+}
+
+// Models returns the models of the first endpoint that answers
+func (p *failoverProvider) Models(ctx context.Context) ([]string, error) {
+	var lastErr error = &noBackendsError{}
+	for _, ep := range p.endpoints {
+		if ep.breaker.Open() {
+			continue
+		}
+		models, err := ep.provider.Models(ctx)
+		if err == nil {
+			return models, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (p *failoverProvider) SupportsVision(ctx context.Context, model string) (bool, error) {
+	var lastErr error = &noBackendsError{}
+	for _, ep := range p.endpoints {
+		if ep.breaker.Open() {
+			continue
+		}
+		supported, err := ep.provider.SupportsVision(ctx, model)
+		if err == nil {
+			return supported, nil
+		}
+		lastErr = err
+	}
+	return false, lastErr
+}
+
+// noBackendsError is returned when every breaker is open, users get the connection error reply
+type noBackendsError struct{}
+
+func (e *noBackendsError) Error() string {
+	return errNoBackends.Error()
+}
+
+func (e *noBackendsError) Unwrap() []error {
+	return []error{errNoBackends, ollama.ErrConnection}
+}
+
+// isOllama reports whether ollama servers answer for the provider
+func isOllama(p Provider) bool {
+	switch p := p.(type) {
+	case ollamaProvider:
+		return true
+	case *failoverProvider:
+		return p.ollama
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+// fakeProvider answers with the errors set per model, requested models are recorded
+type fakeProvider struct {
+	errs   map[string]error
+	models []string
+}
+
+func (p *fakeProvider) Chat(ctx context.Context, req *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+	p.models = append(p.models, req.Model)
+	if err := p.errs[req.Model]; err != nil {
+		return nil, err
+	}
+	return &ollama.ChatResponse{Model: req.Model, Message: ollama.MakeMessage("assistant", "hi"), Done: true}, nil
+}
+
+func (p *fakeProvider) ChatStream(ctx context.Context, req *ollama.ChatRequest, fn func(*ollama.ChatResponse) error) (*ollama.ChatResponse, error) {
+	p.models = append(p.models, req.Model)
+	fn(&ollama.ChatResponse{Message: ollama.MakeMessage("assistant", "h")})
+	if err := p.errs[req.Model]; err != nil {
+		return nil, err
+	}
+	return &ollama.ChatResponse{Model: req.Model, Message: ollama.MakeMessage("assistant", "hi"), Done: true}, nil
+}
+
+func (p *fakeProvider) Models(ctx context.Context) ([]string, error) {
+	return []string{"llama"}, nil
+}
+
+func (p *fakeProvider) SupportsVision(ctx context.Context, model string) (bool, error) {
+	return false, nil
+}
+
+func newTestFailover(models []string, providers ...*fakeProvider) *failoverProvider {
+	p := &failoverProvider{name: "test", models: models}
+	for i, fp := range providers {
+		p.endpoints = append(p.endpoints, &endpoint{url: string(rune('a' + i)), provider: fp, breaker: newBreaker(2, time.Minute)})
+	}
+	return p
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	br := newBreaker(1, time.Minute)
+	br.now = func() time.Time { return now }
+
+	br.Failure()
+	now = now.Add(time.Minute)
+
+	if br.Open() {
+		t.Fatalf("breaker must be half-open after the cooldown")
+	}
+	if !br.Allow() {
+		t.Fatalf("breaker must let a trial through after the cooldown")
+	}
+	if br.Allow() || !br.Open() {
+		t.Fatalf("only one trial may run at a time")
+	}
+
+	br.Release()
+	if !br.Allow() {
+		t.Fatalf("a released trial lets the next request try")
+	}
+	br.Success()
+	if !br.Allow() || !br.Allow() {
+		t.Fatalf("successful trial must close the breaker")
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	br := newBreaker(2, time.Minute)
+	br.now = func() time.Time { return now }
+
+	br.Failure()
+	if !br.Allow() {
+		t.Fatalf("breaker must stay closed below the threshold")
+	}
+	br.Failure()
+	if br.Allow() {
+		t.Fatalf("breaker must open at the threshold")
+	}
+
+	now = now.Add(time.Minute)
+	if !br.Allow() {
+		t.Fatalf("breaker must let a trial through after the cooldown")
+	}
+	br.Failure()
+	if br.Allow() {
+		t.Fatalf("failed trial must open the breaker again")
+	}
+
+	now = now.Add(time.Minute)
+	br.Success()
+	br.Failure()
+	if !br.Allow() {
+		t.Errorf("success must reset the failures")
+	}
+}
+
+func TestFailoverEndpoints(t *testing.T) {
+	down := &fakeProvider{errs: map[string]error{"llama": ollama.ErrConnection}}
+	up := &fakeProvider{}
+	p := newTestFailover(nil, down, up)
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"}); err != nil {
+			t.Fatalf("Chat: %v", err)
+		}
+	}
+
+	// The breaker opens after two failures, the third request goes straight to the fallback
+	if len(down.models) != 2 || len(up.models) != 3 {
+		t.Errorf("unexpected calls: down %v, up %v", down.models, up.models)
+	}
+}
+
+func TestFailoverModels(t *testing.T) {
+	missing := &ollama.StatusError{StatusCode: 404, ErrorMessage: `model "llama" not found`}
+	fp := &fakeProvider{errs: map[string]error{"llama": missing}}
+	p := newTestFailover([]string{"llama", "qwen"}, fp)
+
+	resp, err := p.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Model != "qwen" || len(fp.models) != 2 {
+		t.Errorf("expected the fallback model, got %s after %v", resp.Model, fp.models)
+	}
+	if !p.endpoints[0].breaker.Allow() || p.endpoints[0].breaker.failures != 0 {
+		t.Errorf("missing model must not count as an endpoint failure")
+	}
+}
+
+func TestFailoverStops(t *testing.T) {
+	overflow := &ollama.StatusError{StatusCode: 400, ErrorMessage: "input exceeds the context length"}
+	first := &fakeProvider{errs: map[string]error{"llama": overflow}}
+	second := &fakeProvider{}
+	p := newTestFailover(nil, first, second)
+
+	if _, err := p.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"}); !errors.Is(err, ollama.ErrContextOverflow) {
+		t.Errorf("expected context overflow, got %v", err)
+	}
+	if len(second.models) != 0 {
+		t.Errorf("context overflow must not fail over")
+	}
+
+	// A started stream can't move to another server
+	first.errs["llama"] = ollama.ErrServer
+	_, err := p.ChatStream(context.Background(), &ollama.ChatRequest{Model: "llama"}, func(*ollama.ChatResponse) error { return nil })
+	if !errors.Is(err, ollama.ErrServer) || len(second.models) != 0 {
+		t.Errorf("expected the stream error without failover, got %v", err)
+	}
+}
+
+func TestFailoverAllOpen(t *testing.T) {
+	fp := &fakeProvider{errs: map[string]error{"llama": ollama.ErrServer}}
+	p := newTestFailover(nil, fp)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"}); !errors.Is(err, ollama.ErrServer) {
+			t.Fatalf("expected server error, got %v", err)
+		}
+	}
+
+	_, err := p.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"})
+	if !errors.Is(err, errNoBackends) || !errors.Is(err, ollama.ErrConnection) {
+		t.Errorf("expected no backends error, got %v", err)
+	}
+	if len(fp.models) != 2 {
+		t.Errorf("open endpoint must not be called, got %d calls", len(fp.models))
+	}
+}
+
+// slowProvider answers when the request deadline has passed, like a long generation
+type slowProvider struct {
+	fakeProvider
+}
+
+func (p *slowProvider) Chat(ctx context.Context, req *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("chat: %w", ollama.Classify(ctx.Err()))
+}
+
+func TestFailoverRequestDeadline(t *testing.T) {
+	second := &fakeProvider{}
+	p := &failoverProvider{name: "test", endpoints: []*endpoint{
+		{url: "a", provider: &slowProvider{}, breaker: newBreaker(2, time.Minute)},
+		{url: "b", provider: second, breaker: newBreaker(2, time.Minute)},
+	}}
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := p.Chat(ctx, &ollama.ChatRequest{Model: "llama"})
+		cancel()
+		if !errors.Is(err, ollama.ErrTimeout) {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+	}
+
+	if p.endpoints[0].breaker.Open() || p.endpoints[0].breaker.failures != 0 {
+		t.Errorf("request deadlines must not open the breaker")
+	}
+	if len(second.models) != 0 {
+		t.Errorf("an expired request must not fail over")
+	}
+}
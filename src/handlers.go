@@ -2,17 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
@@ -28,18 +33,20 @@ var regexLinks = regexp.MustCompile(`(?mi)\b(?:http|https|www)\S+`)
 
 var regexGif = regexp.MustCompile(`(?mi)\[(?:gif|гиф)\s*-\s*(.*?)\]`)
 
-
 func handlers(tgBot *telebot.Bot, bot *bot) {
+	bot.registerCommands(tgBot)
+
 	tgBot.Handle(telebot.OnText, bot.botMiddleware(bot.handleMessage))
 	tgBot.Handle(telebot.OnMedia, bot.botMiddleware(bot.handleMessage))
 }
 
-
 // Deny messages from not witelisted users and chats
 func validateChat(config *Config, c telebot.Context) bool {
-	chatID := c.Chat().ID
+	if slices.Contains(config.AllowedChats, c.Chat().ID) {
+		return true
+	}
 
-	if chatID == config.ChatGroupID {
+	if c.Sender() != nil && slices.Contains(config.AllowedUsers, c.Sender().ID) {
 		return true
 	}
 
@@ -53,7 +60,7 @@ func removeLinks(text string) string {
 }
 
 func (b *bot) containsTriggerWord(message string) bool {
-	for _, word := range b.config.TriggerWords {
+	for _, word := range b.cfg().TriggerWords {
 		if strings.Contains(strings.ToLower(message), strings.ToLower(word)) {
 			return true
 		}
@@ -64,15 +71,10 @@ func (b *bot) containsTriggerWord(message string) bool {
 // Middleware for bot context
 func (b *bot) botMiddleware(next telebot.HandlerFunc) telebot.HandlerFunc {
 	return func(c telebot.Context) error {
+		logTelegram.Debug("Message received", "chat", c.Chat().ID, "user", c.Sender().ID, "text", c.Message().Text)
 
-		if b.config.EnableLog {
-			log.Printf("[%d] %d: %s", c.Chat().ID, c.Sender().ID, c.Message().Text)
-		}
-
-		if !validateChat(b.config, c) {
-			if b.config.EnableLog {
-				log.Printf("ACCESS DENY - chat_id:%v user_id:%v \n", c.Chat().ID, c.Sender().ID)
-			}
+		if !validateChat(b.cfg(), c) {
+			logTelegram.Debug("Access denied", "chat", c.Chat().ID, "user", c.Sender().ID)
 			return nil
 		}
 
@@ -88,6 +90,15 @@ func (b *bot) botMiddleware(next telebot.HandlerFunc) telebot.HandlerFunc {
 			c.Set(botMentionKey, true)
 		}
 
+		// Limit only messages that would be answered by the LLM, commands are cheap
+		if !strings.HasPrefix(c.Text(), "/") &&
+			!c.Message().Time().Before(b.startTime) &&
+			b.isNeedProcessAnswer(c.Text(), c) &&
+			!b.checkRateLimit(c) {
+
+			return nil
+		}
+
 		return next(c)
 	}
 }
@@ -99,6 +110,10 @@ func (b *bot) isNeedProcessAnswer(message string, c telebot.Context) bool {
 		return false
 	}
 
+	if c.Chat().Type == telebot.ChatPrivate {
+		return true
+	}
+
 	if b.containsTriggerWord(strings.ToLower(message)) {
 		return true
 	}
@@ -114,38 +129,73 @@ func (b *bot) isNeedProcessAnswer(message string, c telebot.Context) bool {
 	return false
 }
 
-func fetchPreview(link string) string {
+const (
+	previewTimeout = 10 * time.Second
+	maxPreviewBody = 1 << 20
+)
+
+// previewClient fetches link previews, the url may come from the model so private addresses are refused
+var previewClient = &http.Client{
+	Timeout: previewTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: previewTimeout,
+			Control: checkPreviewAddr,
+		}).DialContext,
+		TLSHandshakeTimeout: previewTimeout,
+	},
+}
+
+// checkPreviewAddr runs for the resolved address of every connection, redirects included
+func checkPreviewAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+	return nil
+}
+
+func fetchPreview(ctx context.Context, link string) string {
 
 	// check url for valid
 	u, err := url.Parse(link)
 	if err != nil || u.Scheme == "" || u.Scheme != "https" || u.Host == "" {
 		return ""
 	}
-	// check if url not localhost or local ip's
-	if u.Host == "localhost" || strings.HasPrefix(u.Host, "127.0.0.") || strings.HasPrefix(u.Host, "192.168.") {
-		return ""
-	}
 
 	previewText := ""
 
 	// Fetch preview text from URL
-	resp, err := http.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		log.Printf("Error fetching preview: %v", err)
+		return ""
+	}
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		logTelegram.Warn("Error fetching preview", "error", err)
 		return ""
 	}
 	defer resp.Body.Close()
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxPreviewBody))
 	if err != nil {
-		log.Printf("Error fetching preview: %v", err)
+		logTelegram.Warn("Error fetching preview", "error", err)
 		return ""
 	}
 
 	// Parse the HTML content and extract text from <header title> and <header description>
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(bodyBytes))
 	if err != nil {
-		log.Printf("Error parsing HTML: %v", err)
+		logTelegram.Warn("Error parsing HTML", "error", err)
 		return ""
 	}
 
@@ -172,7 +222,7 @@ func (b *bot) processInputMessage(c telebot.Context) string {
 	if c.Message().PreviewOptions != nil && !c.Message().PreviewOptions.Disabled {
 		if c.Message().PreviewOptions.URL != "" {
 
-			previewText := fetchPreview(c.Message().PreviewOptions.URL)
+			previewText := fetchPreview(context.Background(), c.Message().PreviewOptions.URL)
 
 			if previewText != "" {
 				message = fmt.Sprintf("User send link with text: %s", previewText)
@@ -180,6 +230,10 @@ func (b *bot) processInputMessage(c telebot.Context) string {
 		}
 	}
 
+	if message == "" && b.cfg().EnableVision {
+		message = describeMedia(c.Message())
+	}
+
 	if message == "" {
 		return ""
 	}
@@ -241,15 +295,15 @@ func (b *bot) processOutputMessage(msg string) string {
 		for _, v := range tmp {
 			msg, ok := v.(string)
 			if !ok {
-				log.Println("ERROR: When cast string")
+				logTelegram.Error("Error casting reply to string", "value", v)
 			} else {
 				replayMesage = msg
 			}
 		}
 	}
 
-	isRemoveFromReplay := strings.Index(replayMesage, b.config.RemoveFromReplay)
-	replayMesage = strings.ReplaceAll(replayMesage, b.config.RemoveFromReplay, "")
+	isRemoveFromReplay := strings.Index(replayMesage, b.cfg().RemoveFromReplay)
+	replayMesage = strings.ReplaceAll(replayMesage, b.cfg().RemoveFromReplay, "")
 	// TODO remove (fix when message contains different substr (exmaple - "Assistant:" got - "Ассистант:" ))
 	if isRemoveFromReplay == -1 {
 		index := strings.Index(replayMesage, ":")
@@ -261,8 +315,6 @@ func (b *bot) processOutputMessage(msg string) string {
 	return replayMesage
 }
 
-
-
 func (b *bot) processCommands(c telebot.Context) (string, bool) {
 	isMentioned, ok := c.Get(botMentionKey).(bool)
 	if !ok {
@@ -274,7 +326,6 @@ func (b *bot) processCommands(c telebot.Context) (string, bool) {
 	}
 
 	text := removeLinks(c.Text())
-	rs := ""
 
 	// Trim mention
 	text = strings.TrimPrefix(text, "@")
@@ -289,52 +340,24 @@ func (b *bot) processCommands(c telebot.Context) (string, bool) {
 
 	text = strings.TrimSpace(strings.TrimSpace(cmds[1]))
 
-	switch {
-	case strings.HasPrefix(text, "что ты помнишь"):
-		if len(b.chatContexts.Memory.Data) == 0 {
-			return "", false
-		}
-
-		rs = fmt.Sprintf("Меня просили запомнить:\n%s", b.chatContexts.Memory.GetList())
-		return rs, true
-
-	case strings.HasPrefix(text, "запомни"):
-
-		cmdText := strings.SplitN(text, " ", 2)
-		if len(cmdText) < 2 {
-			return "", false
-		}
-
-		b.chatContexts.Memory.Add(cmdText[1])
-		rs = fmt.Sprintf("Теперь я помню: %s", cmdText[1])
-		return rs, true
-
-	case strings.HasPrefix(text, "забудь"):
-
-		cmdText := strings.SplitN(text, " ", 2)
-		if len(cmdText) < 2 {
-			return "", false
-		}
-
-		index, err := strconv.Atoi(cmdText[1])
-		if err != nil {
-			return "", false
-		}
-
-		old, ok := b.chatContexts.Memory.Remove(index - 1)
-		if !ok {
-			return "", false
-		}
+	cmd, args := b.findAlias(text)
+	if cmd == nil {
+		return "", false
+	}
 
-		rs = fmt.Sprintf("Забыл: %s", old)
-		return rs, true
+	rs, err := b.runCommand(c, cmd, args)
 
+	var roleErr *roleError
+	if errors.As(err, &roleErr) {
+		return roleErr.message(), true
+	}
+	if err != nil {
+		return "", false
 	}
 
-	return "", false
+	return rs, true
 }
 
-
 func (b *bot) handleMessage(c telebot.Context) error {
 	cmdResult, cmdOk := b.processCommands(c)
 
@@ -352,6 +375,8 @@ func (b *bot) handleMessage(c telebot.Context) error {
 		return nil
 	}
 
+	chat := b.chatContexts.Get(c.Chat().ID)
+
 	var userRole UserType
 
 	switch c.Sender().ID {
@@ -367,41 +392,106 @@ func (b *bot) handleMessage(c telebot.Context) error {
 		Message:  message,
 	}
 
-	b.chatContexts.History.Add(newMessage)
-
-	// Skip old message when receive missing updates
-	if c.Message().Time().Before(b.startTime) {
+	// Messages sent while the bot was offline
+	backlog := c.Message().Time().Before(b.startTime)
+	policy := b.cfg().BacklogPolicy
+	if backlog && policy == backlogIgnore {
 		return nil
 	}
 
+	chat.History.Add(newMessage)
+
 	if !b.isNeedProcessAnswer(message, c) {
 		return nil
 	}
 
-	response := make(chan string)
-	defer close(response)
+	if backlog {
+		if policy == backlogAnswerLatest {
+			// The update counts as handled once the answer is sent or a later message replaces it
+			id := c.Update().ID
+			b.updates.hold(id)
+			b.backlog.schedule(chat.Chat, c.Message().ID, func() { b.answerBacklog(c, chat, newMessage) }, func() { b.updates.done(id) })
+		}
+		return nil
+	}
 
-	payload := b.makeChatRequest(newMessage)
-	b.llmChan <- &data{payload, c, response}
+	return b.answerMessage(c, chat, newMessage)
+}
 
-	replayMesage := <-response
+// answerBacklog answers the latest backlog message of a chat, it runs outside of telegram handlers
+func (b *bot) answerBacklog(c telebot.Context, chat *ChatContext, newMessage Message) {
+	if !b.beginHandler() {
+		return
+	}
+	defer b.inflight.Done()
 
-	if replayMesage == "" {
-		log.Println("WARNING: Empty response from ollama")
+	if err := b.answerMessage(c, chat, newMessage); err != nil {
+		logTelegram.Error("Error answering backlog message", "chat", chat.Chat, "error", err)
+	}
+	b.updates.done(c.Update().ID)
+}
+
+// answerMessage queues the message for the LLM and sends the answer
+func (b *bot) answerMessage(c telebot.Context, chat *ChatContext, newMessage Message) error {
+	response := make(chan llmReply, 1)
+
+	if b.modelSupportsVision(chat) {
+		newMessage.Images = b.loadImages(c)
+	}
+
+	var stream *streamReply
+	if b.cfg().EnableStream {
+		stream = b.newStreamReply(c)
+	}
+
+	role := RoleUser
+	if b.cfg().EnableTools {
+		role = b.roleOf(c)
+	}
+
+	// The request is built by the worker, memory recall runs under the queue limits
+	err := b.queue.Submit(&data{message: newMessage, ctx: c, chat: chat, role: role, response: response, stream: stream})
+	if errors.Is(err, ErrQueueFull) {
+		logLLM.Warn("Queue is full, request dropped", "chat", chat.Chat)
+		metricErrors.WithLabelValues("queue_full").Inc()
+		return b.send(b.queueFullMessage(), c)
+	}
+	if errors.Is(err, ErrShuttingDown) {
+		return b.send(b.shutdownMessage(), c)
+	}
+	if err != nil {
+		return err
+	}
+	metricRequests.WithLabelValues(strconv.FormatInt(chat.Chat, 10)).Inc()
+
+	reply := <-response
+
+	if reply.err != nil || reply.text == "" {
+		if stream != nil {
+			stream.Abort()
+		}
+		if reply.err != nil {
+			return b.send(b.errorReply(b.chatModel(chat), reply.err), c)
+		}
+		logLLM.Warn("Empty response from the model", "chat", chat.Chat)
 		return nil
 	}
 
-	replayMesage = b.processOutputMessage(replayMesage)
+	replayMesage := b.processOutputMessage(reply.text)
 
 	replay, storeReplay := b.makeReplay(replayMesage)
 
-	err := b.send(replay, c)
+	if stream != nil {
+		err = stream.Finish(b, replay)
+	} else {
+		err = b.send(replay, c)
+	}
 	if err != nil {
 		return err
 	}
 
 	if storeReplay {
-		b.chatContexts.History.Add(Message{UserType: UserTypeAI, Message: replayMesage})
+		chat.History.Add(Message{UserType: UserTypeAI, Message: replayMesage})
 	}
 
 	return nil
@@ -417,7 +507,7 @@ func (b *bot) makeReplay(replayMesage string) (any, bool) {
 		if len(match) > 1 {
 			res, err := b.giphy.Translate(strings.Split(match[1], " "))
 			if err != nil {
-				log.Printf("Error: %v", err)
+				logTelegram.Error("Error searching giphy", "error", err)
 				return nil, false
 			}
 
@@ -442,19 +532,8 @@ func (b *bot) send(replay any, c telebot.Context) error {
 
 	switch v := replay.(type) {
 	case string:
-		v = escapeMarkdownV2(v)
-
-		if utf8.RuneCountInString(v) > 4000 {
-			runes := []rune(v)
-			for i := 0; i < len(runes); i += 4000 {
-				end := i + 4000
-				if end > len(runes) {
-					end = len(runes)
-				}
-				rpls = append(rpls, string(runes[i:end]))
-			}
-		} else {
-			rpls = append(rpls, v)
+		for _, part := range splitMessage(escapeMarkdownV2(v), maxMessageLength) {
+			rpls = append(rpls, part)
 		}
 
 	case *telebot.Animation:
@@ -464,7 +543,8 @@ func (b *bot) send(replay any, c telebot.Context) error {
 	for _, r := range rpls {
 		err := c.Send(r, replayOpts)
 		if err != nil {
-			log.Printf("Send replay error: %v, replay string: %v\n", err, replay)
+			logTelegram.Error("Error sending reply", "chat", c.Chat().ID, "error", err, "text", fmt.Sprint(replay))
+			metricTelegramFailures.WithLabelValues("send").Inc()
 			return err
 		}
 	}
@@ -475,122 +555,214 @@ func (b *bot) send(replay any, c telebot.Context) error {
 func (b *bot) SendMessageToChatGroup(chatID int64, msg string) error {
 	if chatID != 0 {
 		if _, err := b.tgBot.Send(&telebot.Chat{ID: chatID}, msg, telebot.Silent); err != nil {
+			metricTelegramFailures.WithLabelValues("send").Inc()
 			return fmt.Errorf("Cant send message: %v", err)
 		}
-		if b.config.EnableLog {
-			log.Printf("Message sent to chat group: %v msg: %v \n", chatID, msg)
-		}
+		logTelegram.Debug("Message sent to chat group", "chat", chatID, "text", msg)
 	}
-	b.chatContexts.History.Add(Message{UserType: UserTypeAI, Message: msg})
+	b.chatContexts.Get(chatID).History.Add(Message{UserType: UserTypeAI, Message: msg})
 	return nil
 }
 
-func (b *bot) makeChatRequest(newMsg Message) *ollama.ChatRequest {
-	systemMessage := ollama.MakeMessage(string(UserTypeSystem), b.config.SystemPrompt)
+func (b *bot) makeChatRequest(ctx context.Context, chat *ChatContext, newMsg Message) *ollama.ChatRequest {
+	model := b.chatModel(chat)
+	options := b.chatOptions(chat)
 
-	if len(b.chatContexts.Memory.Data) > 0 {
-		systemMessage.Content = fmt.Sprintf("%s\nТебя просили запомнить:\n%s", systemMessage.Content, b.chatContexts.Memory.GetList())
-	}
+	systemMessage := ollama.MakeMessage(string(UserTypeSystem), b.chatSystemPrompt(chat))
 
-	messages := []ollama.Message{systemMessage}
+	if b.cfg().EmbeddingModel != "" {
+		systemMessage.Content += b.recallPrompt(ctx, chat, newMsg.Message)
+	} else if chat.Memory.Len() > 0 {
+		systemMessage.Content = fmt.Sprintf("%s\nТебя просили запомнить:\n%s", systemMessage.Content, chat.Memory.GetList())
+	}
 
-	for _, msg := range b.chatContexts.History.GetAll() {
-		messages = append(messages, ollama.MakeMessage(string(msg.UserType), msg.Message))
+	if summary := chat.Summary.Get(); summary != "" {
+		systemMessage.Content = fmt.Sprintf("%s\nКратко о чём говорили раньше:\n%s", systemMessage.Content, summary)
 	}
 
-	messages = append(messages, ollama.MakeMessage(string(newMsg.UserType), newMsg.Message))
+	lastMessage := ollama.MakeMessage(string(newMsg.UserType), newMsg.Message)
+	lastMessage.Images = newMsg.Images
 
 	payload := &ollama.ChatRequest{
-		Model:    b.config.Model,
-		Messages: messages,
+		Model: model,
 		AdvancedParams: ollama.AdvancedParams{
-			Options: &ollama.Options{
-				Temperature: b.config.Temperature,
-				NumCtx:      b.config.NumCtx,
-			},
-			Stream: false,
+			Options: options,
+			Stream:  b.cfg().EnableStream,
 			// Format: "json",
 		},
 	}
 
-	return payload
-}
+	if b.cfg().EnableTools {
+		payload.Tools = b.tools.Definitions()
+	}
 
-func (b *bot) processOllama() {
-	// Listen channel for new requests
-	for data := range b.llmChan {
-		log.Println("Process ollama request")
+	// System prompt, memory and the new message are always sent, history gets what is left
+	budget := b.contextBudget(options)
+	budget -= b.tokens.Estimate(model, systemMessage.Content)
+	budget -= b.tokens.Estimate(model, lastMessage.Content) + len(lastMessage.Images)*imageTokens
+	if len(payload.Tools) > 0 {
+		tools, _ := json.Marshal(payload.Tools)
+		budget -= b.tokens.Estimate(model, string(tools))
+	}
 
-		err := data.ctx.Notify(telebot.Typing)
-		if err != nil {
-			log.Printf("Send Notify error: %v\n", err)
+	history := chat.History.GetAll()
+	// The new message is already in the history, messages that came while it was queued are left out too
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].UserType == newMsg.UserType && history[i].Message == newMsg.Message {
+			history = history[:i]
+			break
 		}
+	}
 
-		resp, err := b.sendRequestOllama(data.request)
+	fitted := b.fitHistory(model, history, budget)
+	if dropped := len(history) - len(fitted); dropped > 0 {
+		logLLM.Debug("Context budget exceeded, history messages dropped", "chat", chat.Chat, "dropped", dropped, "history", len(history))
+	}
 
-		if err != nil {
-			log.Printf("Error: %v\n", err)
-			data.response <- ""
-			continue
-		}
+	payload.Messages = make([]ollama.Message, 0, len(fitted)+2)
+	payload.Messages = append(payload.Messages, systemMessage)
+	for _, msg := range fitted {
+		payload.Messages = append(payload.Messages, ollama.MakeMessage(string(msg.UserType), msg.Message))
+	}
+	payload.Messages = append(payload.Messages, lastMessage)
+
+	return payload
+}
 
-		data.response <- resp
+func (b *bot) processOllama(ctx context.Context, data *data) {
+	data.request = b.makeChatRequest(ctx, data.chat, data.message)
+	logLLM.Info("Processing request", "chat", data.chat.Chat, "model", data.request.Model)
 
-		time.Sleep(5 * time.Second)
+	err := data.ctx.Notify(telebot.Typing)
+	if err != nil {
+		logTelegram.Warn("Error sending typing notification", "error", err)
 	}
-}
 
-func (b *bot) sendRequestOllama(payload *ollama.ChatRequest) (string, error) {
+	start := time.Now()
+	resp, err := b.sendRequestOllama(withRole(ctx, data.role), data.chat, data.request, data.stream)
 
-	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return "", nil
+		// The queue cancels requests that did not finish before the shutdown deadline
+		if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+			err = fmt.Errorf("%w: %w", ErrShuttingDown, err)
+		}
+		logLLM.Error("Request failed", "chat", data.chat.Chat, "model", data.request.Model, "error", err)
+		metricErrors.WithLabelValues(errorType(err)).Inc()
+		data.response <- llmReply{err: err}
+		return
 	}
+	metricRequestDuration.WithLabelValues(data.request.Model).Observe(time.Since(start).Seconds())
 
-	if b.config.EnableLog {
-		log.Printf("Request: %s\n", string(jsonData))
+	data.response <- llmReply{text: resp}
+}
+
+func (b *bot) queueFullMessage() string {
+	if b.cfg().QueueFullMessage != "" {
+		return b.cfg().QueueFullMessage
 	}
+	return "Too many requests right now, please try again later."
+}
 
-	// make url request /api/chat
-	serverURL, err := url.JoinPath(b.config.ServerURL, "/api/chat")
-	if err != nil {
-		return "", err
+func (b *bot) shutdownMessage() string {
+	if b.cfg().ShutdownMessage != "" {
+		return b.cfg().ShutdownMessage
 	}
+	return "The bot is restarting, please repeat your request later."
+}
 
-	req, err := http.NewRequest("POST", serverURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", nil
+// errorReply tells the user why the request failed
+func (b *bot) errorReply(model string, err error) string {
+	switch {
+	case errors.Is(err, ErrShuttingDown):
+		return b.shutdownMessage()
+	case errors.Is(err, ollama.ErrModelNotFound):
+		return fmt.Sprintf("Model %s is not available on the server, ask an admin to pull it or choose another one with /model.", model)
+	case errors.Is(err, ollama.ErrContextOverflow):
+		return "The conversation is too long for the model, clear it with /reset or shorten the message."
+	case errors.Is(err, ollama.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "The model took too long to answer, please try again."
+	case errors.Is(err, ollama.ErrConnection):
+		return "The model server is not reachable, please try again later."
+	case errors.Is(err, ollama.ErrServer):
+		return "The model server failed to answer, please try again later."
+	case errors.Is(err, context.Canceled):
+		return "The request was cancelled."
+	default:
+		return "Something went wrong while answering, please try again later."
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", nil
+func (b *bot) sendRequestOllama(ctx context.Context, chat *ChatContext, payload *ollama.ChatRequest, stream *streamReply) (string, error) {
+	maxIterations := b.cfg().MaxToolIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxToolIterations
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	provider := b.chatProvider(chat)
+
+	for i := 0; ; i++ {
+		// Out of iterations, ask for the final answer without tools
+		if i == maxIterations {
+			logLLM.Warn("Tool call limit reached", "chat", chat.Chat, "limit", maxIterations)
+			payload.Tools = nil
+		}
+
+		response, err := b.chatOllama(ctx, provider, payload, stream)
+		if err != nil {
+			return "", err
+		}
 
-	var response ollama.ChatResponse
+		if len(response.Message.ToolCalls) == 0 || len(payload.Tools) == 0 {
+			return response.Message.Content, nil
+		}
 
-	if b.config.EnableLog {
-		log.Printf("Responce: %s\n", string(body))
+		payload.Messages = append(payload.Messages, response.Message)
+		for _, call := range response.Message.ToolCalls {
+			payload.Messages = append(payload.Messages, b.tools.Call(ctx, chat, call))
+		}
 	}
+}
 
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return "", err
+func (b *bot) chatOllama(ctx context.Context, provider Provider, payload *ollama.ChatRequest, stream *streamReply) (*ollama.ChatResponse, error) {
+
+	if logLLM.Enabled(ctx, slog.LevelDebug) {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		logLLM.Debug("Chat request", "request", string(jsonData))
 	}
 
-	res := response.Message.Content
+	var (
+		response *ollama.ChatResponse
+		err      error
+	)
+
+	if stream != nil {
+		var text strings.Builder
+		response, err = provider.ChatStream(ctx, payload, func(chunk *ollama.ChatResponse) error {
+			text.WriteString(chunk.Message.Content)
+			if !chunk.Done {
+				stream.Update(b.processOutputMessage(text.String()))
+			}
+			return nil
+		})
+	} else {
+		response, err = provider.Chat(ctx, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	// Log
-	if b.config.EnableLog {
-		log.Printf("[responce message] %s\n", res)
+	b.tokens.Observe(payload.Model, promptChars(payload), len(payload.Messages), response.PromptEvalCount)
+	observeChat(payload.Model, response.Metrics)
+	if isOllama(provider) {
+		b.markOllamaSuccess()
 	}
 
-	return res, nil
+	logLLM.Debug("Chat response", "model", response.Model, "response", response.Message.Content)
+
+	return response, nil
 }
 
 // Регулярное выражение для поиска части (...) встроенных ссылок и кастомных эмодзи
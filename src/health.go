@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// healthPoller wraps the telegram poller to tell whether it is running and when the last update came
+type healthPoller struct {
+	poller     telebot.Poller
+	running    atomic.Bool
+	lastUpdate atomic.Int64
+
+	// dispatch handles the updates instead of telebot when set
+	dispatch func(telebot.Update)
+}
+
+func newHealthPoller(poller telebot.Poller) *healthPoller {
+	hp := &healthPoller{}
+	hp.poller = telebot.NewMiddlewarePoller(poller, func(upd *telebot.Update) bool {
+		hp.lastUpdate.Store(time.Now().UnixNano())
+		return true
+	})
+	return hp
+}
+
+func (hp *healthPoller) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	hp.running.Store(true)
+	defer hp.running.Store(false)
+
+	if hp.dispatch == nil {
+		hp.poller.Poll(b, dest, stop)
+		return
+	}
+
+	updates := make(chan telebot.Update)
+	go func() {
+		hp.poller.Poll(b, updates, stop)
+		close(updates)
+	}()
+
+	for upd := range updates {
+		select {
+		case <-stop:
+			// Not handled, the saved offset stays before it
+		default:
+			hp.dispatch(upd)
+		}
+	}
+}
+
+type pollerReport struct {
+	Running    bool       `json:"running"`
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+}
+
+type queueReport struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+type ollamaReport struct {
+	Provider     string     `json:"provider"`
+	URL          string     `json:"url"`
+	Reachable    bool       `json:"reachable"`
+	LastSuccess  *time.Time `json:"lastSuccess,omitempty"`
+	Model        string     `json:"model"`
+	ModelPresent bool       `json:"modelPresent"`
+	Error        string     `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status   string        `json:"status"`
+	Uptime   string        `json:"uptime"`
+	Poller   pollerReport  `json:"poller"`
+	Queue    queueReport   `json:"queue"`
+	Ollama   *ollamaReport `json:"ollama,omitempty"`
+	Problems []string      `json:"problems,omitempty"`
+}
+
+func unixTime(nano int64) *time.Time {
+	if nano == 0 {
+		return nil
+	}
+	t := time.Unix(0, nano)
+	return &t
+}
+
+// markOllamaSuccess records a successful call to ollama
+func (b *bot) markOllamaSuccess() {
+	b.lastOllamaSuccess.Store(time.Now().UnixNano())
+}
+
+// healthReport describes the state of the bot, the poller must be running to be alive
+func (b *bot) healthReport() *healthReport {
+	rs := &healthReport{
+		Status: "ok",
+		Uptime: time.Since(b.startTime).Round(time.Second).String(),
+	}
+
+	if b.poller != nil {
+		rs.Poller.Running = b.poller.running.Load()
+		rs.Poller.LastUpdate = unixTime(b.poller.lastUpdate.Load())
+	}
+	if !rs.Poller.Running {
+		rs.Problems = append(rs.Problems, "telegram poller is not running")
+	}
+
+	if b.queue != nil {
+		rs.Queue = queueReport{Depth: b.queue.Len(), Capacity: b.queue.Cap()}
+	}
+
+	return rs
+}
+
+// readinessReport extends the health report with checks of the default provider, requests can be served when it has no problems
+func (b *bot) readinessReport(ctx context.Context) *healthReport {
+	rs := b.healthReport()
+
+	if b.shuttingDown.Load() {
+		rs.Problems = append(rs.Problems, "bot is shutting down")
+	}
+
+	if rs.Queue.Capacity > 0 && rs.Queue.Depth >= rs.Queue.Capacity {
+		rs.Problems = append(rs.Problems, "request queue is full")
+	}
+
+	name := b.defaultProviderName()
+	model := b.providerModel(name)
+	report := &ollamaReport{
+		Provider: name,
+		URL:      b.ollama.BaseURL(),
+		Model:    model,
+	}
+	if pc, ok := b.cfg().Providers[name]; ok {
+		report.URL = pc.URL
+	}
+	rs.Ollama = report
+
+	provider, ok := b.providers[name]
+	if !ok {
+		provider = ollamaProvider{b.ollama}
+	}
+
+	models, err := provider.Models(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		rs.Problems = append(rs.Problems, name+" is not reachable")
+	} else {
+		if isOllama(provider) {
+			b.markOllamaSuccess()
+		}
+		report.Reachable = true
+		for _, m := range models {
+			if modelMatches(m, model) {
+				report.ModelPresent = true
+				break
+			}
+		}
+		if !report.ModelPresent {
+			rs.Problems = append(rs.Problems, "model "+model+" is not available in "+name)
+		}
+	}
+	report.LastSuccess = unixTime(b.lastOllamaSuccess.Load())
+
+	return rs
+}
+
+// modelMatches compares model names, a name without a tag means the latest tag
+func modelMatches(name, model string) bool {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	return name == model
+}
+
+func writeHealth(w http.ResponseWriter, rs *healthReport) {
+	code := http.StatusOK
+	if len(rs.Problems) > 0 {
+		rs.Status = "fail"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(rs); err != nil {
+		logHTTP.Error("Error writing health report", "error", err)
+	}
+}
+
+func (b *bot) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, b.healthReport())
+}
+
+func (b *bot) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	writeHealth(w, b.readinessReport(ctx))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+	"gopkg.in/telebot.v3"
+)
+
+func newHealthTestBot(t *testing.T, models ...string) *bot {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var list ollama.ListResponse
+		for _, name := range models {
+			list.Models = append(list.Models, ollama.ModelInfo{Name: name})
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := ollama.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bot{
+		config:    &Config{Model: "llama3"},
+		ollama:    client,
+		startTime: time.Now(),
+		poller:    newHealthPoller(nil),
+		queue:     newLLMQueue(1, 1, time.Second, func(ctx context.Context, d *data) {}),
+	}
+	b.poller.running.Store(true)
+	return b
+}
+
+func checkHealth(t *testing.T, b *bot, path string, code int) *healthReport {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	b.newHTTPHandler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != code {
+		t.Errorf("%s returned %d, want %d: %s", path, rec.Code, code, rec.Body)
+	}
+
+	var rs healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&rs); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	return &rs
+}
+
+func TestHealthz(t *testing.T) {
+	b := newHealthTestBot(t)
+
+	if rs := checkHealth(t, b, "/healthz", http.StatusOK); rs.Status != "ok" || rs.Queue.Capacity != 1 {
+		t.Errorf("unexpected report %+v", rs)
+	}
+
+	b.poller.running.Store(false)
+	checkHealth(t, b, "/healthz", http.StatusServiceUnavailable)
+}
+
+func TestReadyz(t *testing.T) {
+	b := newHealthTestBot(t, "mistral:7b", "llama3:latest")
+
+	rs := checkHealth(t, b, "/readyz", http.StatusOK)
+	if rs.Ollama == nil || !rs.Ollama.Reachable || !rs.Ollama.ModelPresent || rs.Ollama.LastSuccess == nil {
+		t.Errorf("unexpected ollama report %+v", rs.Ollama)
+	}
+
+	b.config.Model = "qwen"
+	rs = checkHealth(t, b, "/readyz", http.StatusServiceUnavailable)
+	if rs.Status != "fail" || len(rs.Problems) != 1 {
+		t.Errorf("unexpected report %+v", rs)
+	}
+}
+
+func TestReadyzOllamaDown(t *testing.T) {
+	b := newHealthTestBot(t)
+
+	client, err := ollama.NewClient("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.ollama = client
+
+	if rs := checkHealth(t, b, "/readyz", http.StatusServiceUnavailable); rs.Ollama.Reachable || rs.Ollama.Error == "" {
+		t.Errorf("unexpected ollama report %+v", rs.Ollama)
+	}
+}
+
+func TestReadyzDefaultProvider(t *testing.T) {
+	// The ollama server has no models, readiness must only check the default provider
+	b := newHealthTestBot(t)
+	b.config.DefaultProvider = "cloud"
+	b.config.Providers = map[string]ProviderConfig{"cloud": {Type: providerOpenAI, URL: "https://api.example.com/v1", Model: "llama"}}
+	b.providers = map[string]Provider{"cloud": &fakeProvider{}}
+
+	rs := checkHealth(t, b, "/readyz", http.StatusOK)
+	if rs.Ollama.Provider != "cloud" || rs.Ollama.Model != "llama" || rs.Ollama.URL != "https://api.example.com/v1" || !rs.Ollama.ModelPresent {
+		t.Errorf("unexpected provider report %+v", rs.Ollama)
+	}
+
+	b.config.Providers["cloud"] = ProviderConfig{Type: providerOpenAI, Model: "gpt-4o"}
+	if rs := checkHealth(t, b, "/readyz", http.StatusServiceUnavailable); len(rs.Problems) != 1 {
+		t.Errorf("unexpected report %+v", rs)
+	}
+}
+
+// stubPoller sends the updates before stop and late ones after it
+type stubPoller struct {
+	updates, late []telebot.Update
+}
+
+func (p *stubPoller) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	for _, upd := range p.updates {
+		dest <- upd
+	}
+	<-stop
+	for _, upd := range p.late {
+		dest <- upd
+	}
+}
+
+func TestHealthPollerDispatch(t *testing.T) {
+	hp := newHealthPoller(&stubPoller{
+		updates: []telebot.Update{{ID: 1}, {ID: 2}},
+		late:    []telebot.Update{{ID: 3}},
+	})
+
+	var dispatched []int
+	hp.dispatch = func(upd telebot.Update) {
+		dispatched = append(dispatched, upd.ID)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		hp.Poll(nil, nil, stop)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	<-done
+
+	if !slices.Equal(dispatched, []int{1, 2}) {
+		t.Errorf("updates after stop must not be handled, got %v", dispatched)
+	}
+	if hp.running.Load() || hp.lastUpdate.Load() == 0 {
+		t.Errorf("unexpected poller state")
+	}
+}
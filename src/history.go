@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"os"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	defaultSaveInterval = 60 * time.Second
+
+	// Changes coming together are written at once
+	saveDelay = 2 * time.Second
 )
 
 type UserType string
@@ -16,23 +26,179 @@ const (
 	UserTypeSystem UserType = "system"
 	UserTypeAI     UserType = "assistant"
 	UserTypeUser   UserType = "user"
+	UserTypeTool   UserType = "tool"
 )
 
 type Message struct {
-	UserType UserType `json:"user_type"`
-	Message  string   `json:"message"`
+	UserType UserType           `json:"user_type"`
+	Message  string             `json:"message"`
+	Images   []ollama.ImageData `json:"-"`
 }
 
 type ChatContext struct {
-	Chat    int64        `json:"-"`
-	History *BoundedList `json:"history"`
-	Memory  *Memory     `json:"memory"`
-	filename string		 `json:"-"`
+	Chat     int64         `json:"-"`
+	History  *BoundedList  `json:"history"`
+	Memory   *Memory       `json:"memory"`
+	Settings *ChatSettings `json:"settings"`
+	Summary  *Summary      `json:"summary"`
+	Vectors  *VectorIndex  `json:"-"`
+
+	// Versions count changes, the saved ones are guarded by ChatContexts.saveMu
+	version             atomic.Uint64
+	vectorsVersion      atomic.Uint64
+	savedVersion        uint64
+	savedVectorsVersion uint64
+}
+
+// ChatContexts keeps a ChatContext per chat, contexts are created (and loaded from the store) on first use
+type ChatContexts struct {
+	mu          sync.Mutex
+	chats       map[int64]*ChatContext
+	historySize int
+	store       Store // nil disables persistence
+	onEvict     func(chat *ChatContext, msg Message)
+	changes     chan struct{}
+	saveMu      sync.Mutex
+}
+
+func NewChatContexts(historySize int, store Store) *ChatContexts {
+	return &ChatContexts{
+		chats:       make(map[int64]*ChatContext),
+		historySize: historySize,
+		store:       store,
+		changes:     make(chan struct{}, 1),
+	}
+}
+
+func historyKey(chatID int64) string {
+	return fmt.Sprintf("%d_history", chatID)
+}
+
+func vectorsKey(chatID int64) string {
+	return fmt.Sprintf("%d_vectors", chatID)
+}
+
+// Get returns context of the chat, creating (and loading from the store) it if needed
+func (cc *ChatContexts) Get(chatID int64) *ChatContext {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if ctxChat, ok := cc.chats[chatID]; ok {
+		return ctxChat
+	}
+
+	ctxChat := NewChatContext(chatID, cc.historySize)
+	cc.chats[chatID] = ctxChat
+
+	if cc.store != nil {
+		if err := ctxChat.load(cc.store); err != nil {
+			logHistory.Error("Error loading chat", "chat", chatID, "error", err)
+		}
+	}
+
+	// Hooks are set after loading, it replaces parts of the context
+	if onEvict := cc.onEvict; onEvict != nil {
+		ctxChat.History.onEvict = func(msg Message) {
+			onEvict(ctxChat, msg)
+		}
+	}
+	ctxChat.watch(cc.notify)
+
+	return ctxChat
+}
+
+// OnEvict sets a function called with messages dropped from a full history of any chat
+func (cc *ChatContexts) OnEvict(fn func(chat *ChatContext, msg Message)) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.onEvict = fn
+}
+
+// All returns every context created so far
+func (cc *ChatContexts) All() []*ChatContext {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	all := make([]*ChatContext, 0, len(cc.chats))
+	for _, ctxChat := range cc.chats {
+		all = append(all, ctxChat)
+	}
+	return all
+}
+
+func (cc *ChatContexts) notify() {
+	select {
+	case cc.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Save writes every chat changed since the last save to the store
+func (cc *ChatContexts) Save() error {
+	if cc.store == nil {
+		return nil
+	}
+
+	cc.saveMu.Lock()
+	defer cc.saveMu.Unlock()
+
+	var errs []error
+	for _, ctxChat := range cc.All() {
+		if err := ctxChat.save(cc.store); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", ctxChat.Chat, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run saves changed chats shortly after a change and every interval, failed saves are retried.
+// When ctx is done the chats are saved once more
+func (cc *ChatContexts) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSaveInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-cc.changes:
+			if delay == nil {
+				delay = time.After(saveDelay)
+			}
+			continue
+		case <-delay:
+			delay = nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := cc.Save(); err != nil {
+				logHistory.Error("Error saving history", "error", err)
+			}
+			return
+		}
+
+		if err := cc.Save(); err != nil {
+			logHistory.Error("Error saving history", "error", err)
+		}
+	}
 }
 
 type Memory struct {
 	mu       sync.Mutex `json:"-"`
 	Data     []string   `json:"data"`
+	onChange func()     `json:"-"`
+}
+
+func (m *Memory) MarshalJSON() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return json.Marshal(struct {
+		Data []string `json:"data"`
+	}{m.Data})
 }
 
 func (m *Memory) GetList() string {
@@ -41,16 +207,31 @@ func (m *Memory) GetList() string {
 
 	rs := ""
 	for i, v := range m.Data {
-		rs += fmt.Sprintf("%d. %s\n",i+1, v)
+		rs += fmt.Sprintf("%d. %s\n", i+1, v)
 	}
 	return rs
 }
 
+func (m *Memory) GetAll() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.Data)
+}
+
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.Data)
+}
+
 func (m *Memory) Add(message string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.Data = append(m.Data, message)
+	notify(m.onChange)
 }
 
 // Index from 0 to len-1
@@ -64,50 +245,64 @@ func (m *Memory) Remove(index int) (string, bool) {
 
 	removedString := m.Data[index]
 	m.Data = slices.Delete(m.Data, index, index+1)
+	notify(m.onChange)
 
 	return removedString, true
 }
 
 type BoundedList struct {
-	mu       sync.Mutex 	`json:"-"`
-	Data     []Message 		`json:"data"`
-	limit    int			`json:"-"`
+	mu       sync.Mutex    `json:"-"`
+	Data     []Message     `json:"data"`
+	limit    int           `json:"-"`
+	onEvict  func(Message) `json:"-"`
+	onChange func()        `json:"-"`
 }
 
-func NewChatContext(chatID int64, limit int, filename string, load bool) *ChatContext {
+func NewChatContext(chatID int64, limit int) *ChatContext {
 	bm := &BoundedList{
-		Data:     make([]Message, 0),
-		limit:    limit,
-	}
-
-	ctxChat := &ChatContext{
-		Chat:    chatID,
-		History: bm,
-		Memory:  &Memory{sync.Mutex{}, []string{}},
-		filename: filename,
+		Data:  make([]Message, 0),
+		limit: limit,
 	}
 
-	if !load {
-		return ctxChat
+	return &ChatContext{
+		Chat:     chatID,
+		History:  bm,
+		Memory:   &Memory{Data: []string{}},
+		Settings: &ChatSettings{},
+		Summary:  &Summary{},
+		Vectors:  &VectorIndex{},
 	}
+}
 
-	if err := ctxChat.loadFromFile(); err != nil {
-		log.Println("Error loading from file:", err)
-	}
+func (bm *BoundedList) MarshalJSON() ([]byte, error) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
 
-	return ctxChat
+	return json.Marshal(struct {
+		Data []Message `json:"data"`
+	}{bm.Data})
 }
 
 func (bm *BoundedList) Add(value Message) {
 	bm.mu.Lock()
-	defer bm.mu.Unlock()
 
-	if len(bm.Data) >= bm.limit {
+	var evicted []Message
+	for len(bm.Data) > 0 && len(bm.Data) >= bm.limit {
 		// Remove the oldest element
+		evicted = append(evicted, bm.Data[0])
 		bm.Data = bm.Data[1:]
 	}
 
 	bm.Data = append(bm.Data, value)
+	onEvict := bm.onEvict
+	notify(bm.onChange)
+	bm.mu.Unlock()
+
+	if onEvict != nil {
+		for _, msg := range evicted {
+			onEvict(msg)
+		}
+	}
 }
 
 func (bm *BoundedList) GetAll() []Message {
@@ -124,67 +319,106 @@ func (bm *BoundedList) Clear() {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	clear(bm.Data)
+	bm.Data = bm.Data[:0]
+	notify(bm.onChange)
 }
 
-// Save BoundedList to json file
-func (cc *ChatContext) SaveToFile() error {
-	file, err := os.Create(cc.filename)
-	if err != nil {
-		return err
+// notify calls a change hook if it is set
+func notify(onChange func()) {
+	if onChange != nil {
+		onChange()
 	}
-	defer file.Close()
+}
 
-	// Convert the data to JSON format
-	jsonData, err := json.MarshalIndent(cc, "", "  ")
-	if err != nil {
-		return err
+// watch makes every change of the context mark it for saving and call notify
+func (cc *ChatContext) watch(notify func()) {
+	changed := func() {
+		cc.version.Add(1)
+		notify()
 	}
 
-	// Write the data to the file
-	_, err = file.Write(jsonData)
-	if err != nil {
-		return err
+	cc.History.onChange = changed
+	cc.Memory.onChange = changed
+	cc.Settings.onChange = changed
+	cc.Summary.onChange = changed
+	cc.Vectors.onChange = func() {
+		cc.vectorsVersion.Add(1)
+		notify()
 	}
+}
 
-	log.Printf("History [%d messages] saved to file: %s", len(cc.History.Data), cc.filename)
+// save writes the context to the store if it changed, the vector index is kept under its own key
+func (cc *ChatContext) save(store Store) error {
+	if version := cc.version.Load(); version != cc.savedVersion {
+		jsonData, err := json.MarshalIndent(cc, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := store.Save(historyKey(cc.Chat), jsonData); err != nil {
+			return err
+		}
+		cc.savedVersion = version
+
+		logHistory.Debug("History saved", "chat", cc.Chat, "messages", len(cc.History.GetAll()))
+	}
+
+	if version := cc.vectorsVersion.Load(); version != cc.savedVectorsVersion {
+		jsonData, err := cc.Vectors.marshal()
+		if err != nil {
+			return err
+		}
+		if err := store.Save(vectorsKey(cc.Chat), jsonData); err != nil {
+			return err
+		}
+		cc.savedVectorsVersion = version
+	}
 
 	return nil
 }
 
-// Load BoundedList from file
-func (cc *ChatContext) loadFromFile() error {
-	// Check if file exists before attempting to load
-	if _, err := os.Stat(cc.filename); os.IsNotExist(err) {
-		log.Println("History file does not found.")
-		return nil
-	}
-
-	file, err := os.Open(cc.filename)
-	if err != nil {
+// load reads the context from the store, missing keys leave it empty
+func (cc *ChatContext) load(store Store) error {
+	jsonData, err := store.Load(historyKey(cc.Chat))
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		return err
 	}
-	defer file.Close()
 
-	// Read the data from the file
-	jsonData, err := io.ReadAll(file)
-	if err != nil {
-		return err
+	if err == nil {
+		var newCc ChatContext
+		if err := json.Unmarshal(jsonData, &newCc); err != nil {
+			return err
+		}
+
+		if newCc.Memory != nil {
+			cc.Memory = newCc.Memory
+		}
+		if newCc.Settings != nil {
+			cc.Settings = newCc.Settings
+		}
+		if newCc.Summary != nil {
+			cc.Summary = newCc.Summary
+		}
+		if newCc.History != nil {
+			for _, v := range newCc.History.Data {
+				cc.History.Add(v)
+			}
+		}
+
+		logHistory.Info("History loaded", "chat", cc.Chat, "messages", len(cc.History.Data))
 	}
 
-	var newCc ChatContext
-	// Unmarshal JSON data into a slice of Message structs
-	err = json.Unmarshal(jsonData, &newCc)
+	jsonData, err = store.Load(vectorsKey(cc.Chat))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	cc.Memory = newCc.Memory
-	for _, v := range newCc.History.Data {
-		cc.History.Add(v)
+	if err := json.Unmarshal(jsonData, cc.Vectors); err != nil {
+		return err
 	}
-
-	log.Printf("History [%d messages] loaded", len(cc.History.Data))
+	logHistory.Info("Vector index loaded", "chat", cc.Chat, "entries", cc.Vectors.Len())
 
 	return nil
 }
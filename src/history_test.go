@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestChatContextsGet(t *testing.T) {
+	contexts := NewChatContexts(2, nil)
+
+	first := contexts.Get(1)
+	first.History.Add(Message{UserType: UserTypeUser, Message: "hello"})
+
+	if contexts.Get(1) != first {
+		t.Errorf("expected the same context for the same chat")
+	}
+
+	second := contexts.Get(2)
+	if len(second.History.GetAll()) != 0 {
+		t.Errorf("expected empty history for a new chat")
+	}
+
+	if len(contexts.All()) != 2 {
+		t.Errorf("expected 2 contexts, got %d", len(contexts.All()))
+	}
+}
+
+func TestChatContextsSaveAndLoad(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contexts := NewChatContexts(10, store)
+	contexts.Get(42).History.Add(Message{UserType: UserTypeUser, Message: "hello"})
+	contexts.Get(42).Memory.Add("remember me")
+	if err := contexts.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := NewChatContexts(10, store).Get(42)
+	if history := loaded.History.GetAll(); len(history) != 1 || history[0].Message != "hello" {
+		t.Errorf("unexpected history %+v", history)
+	}
+	if len(loaded.Memory.Data) != 1 {
+		t.Errorf("unexpected memory %+v", loaded.Memory.Data)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const redacted = "[REDACTED]"
+
+// Component loggers, they are replaced by setupLogging
+var (
+	logMain     *slog.Logger
+	logTelegram *slog.Logger
+	logCommands *slog.Logger
+	logLLM      *slog.Logger
+	logHistory  *slog.Logger
+	logHTTP     *slog.Logger
+)
+
+func init() {
+	setComponentLoggers(slog.Default())
+}
+
+func setComponentLoggers(logger *slog.Logger) {
+	logMain = logger.With("component", "main")
+	logTelegram = logger.With("component", "telegram")
+	logCommands = logger.With("component", "commands")
+	logLLM = logger.With("component", "llm")
+	logHistory = logger.With("component", "history")
+	logHTTP = logger.With("component", "http")
+}
+
+// Attributes with secrets are always hidden, attributes with message contents when redactMessages is set
+var (
+	secretKeys  = []string{"token", "bottoken", "apikey", "api_key", "password", "secret", "authorization"}
+	contentKeys = []string{"text", "prompt", "request", "response", "args", "result"}
+)
+
+// telegramTokenRegex matches bot tokens, they are a part of every telegram api url
+var telegramTokenRegex = regexp.MustCompile(`\b\d{6,}:[A-Za-z0-9_-]{30,}\b`)
+
+// redactor hides secrets in log records
+type redactor struct {
+	secrets        []string
+	redactMessages bool
+}
+
+func newRedactor(config *Config) *redactor {
+	r := &redactor{redactMessages: config.LogRedactMessages}
+	for _, secret := range config.secrets() {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+	return r
+}
+
+// redactString replaces known secrets and anything that looks like a bot token
+func (r *redactor) redactString(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return telegramTokenRegex.ReplaceAllString(s, redacted)
+}
+
+// ReplaceAttr is the slog.HandlerOptions hook, errors are converted to strings to be checked too
+func (r *redactor) ReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if slices.Contains(secretKeys, key) {
+		return slog.String(a.Key, redacted)
+	}
+	if r.redactMessages && len(groups) == 0 && slices.Contains(contentKeys, key) {
+		return slog.String(a.Key, fmt.Sprintf("[%d chars]", len(a.Value.String())))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.redactString(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, r.redactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, r.redactString(v.String()))
+		}
+	}
+	return a
+}
+
+// parseLogLevel returns the configured level, enableLog used to turn on the verbose logs
+func parseLogLevel(config *Config) (slog.Level, error) {
+	switch strings.ToLower(config.LogLevel) {
+	case "":
+		if config.EnableLog {
+			return slog.LevelDebug, nil
+		}
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", config.LogLevel)
+}
+
+// newLogger creates the logger described by the config, the closer is nil without a log file
+func newLogger(config *Config) (*slog.Logger, io.Closer, error) {
+	level, err := parseLogLevel(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		out    io.Writer = os.Stderr
+		closer io.Closer
+	)
+	if config.LogFile != "" {
+		file := &lumberjack.Logger{
+			Filename:   config.LogFile,
+			MaxSize:    config.LogMaxSize,
+			MaxBackups: config.LogMaxBackups,
+			MaxAge:     config.LogMaxAge,
+			Compress:   config.LogCompress,
+		}
+		out, closer = file, file
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: newRedactor(config).ReplaceAttr,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(config.LogFormat) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q", config.LogFormat)
+	}
+
+	return slog.New(handler), closer, nil
+}
+
+// setupLogging makes the configured logger the default one, the standard log package of
+// the libraries writes to it as well
+func setupLogging(config *Config) (io.Closer, error) {
+	logger, closer, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.SetDefault(logger)
+	setComponentLoggers(logger)
+	return closer, nil
+}
+
+// fatal logs the error and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func newTestLogger(config *Config) (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: newRedactor(config).ReplaceAttr})
+	return slog.New(handler), &buf
+}
+
+func TestRedactSecrets(t *testing.T) {
+	config := &Config{
+		BotToken:    "123456789:AAEabcdefghijklmnopqrstuvwxyz012345",
+		GiphyAPIKey: "giphy-key",
+		Providers:   map[string]ProviderConfig{"vllm": {APIKey: "sk-provider"}},
+	}
+	logger, buf := newTestLogger(config)
+
+	logger.Info("Calling https://api.telegram.org/bot"+config.BotToken+"/getMe",
+		"error", errors.New("giphy: bad key giphy-key"),
+		"apiKey", "anything",
+		"header", "Bearer sk-provider",
+		"other", "987654321:BBEabcdefghijklmnopqrstuvwxyz012345",
+		"prompt_tokens", 12,
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"AAEabcdefghijklmnopqrstuvwxyz012345", "giphy-key", "anything", "sk-provider", "BBEabcdef"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("secret %q leaked: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "prompt_tokens=12") {
+		t.Errorf("token counters must not be redacted: %s", out)
+	}
+}
+
+func TestRedactMessages(t *testing.T) {
+	logger, buf := newTestLogger(&Config{})
+	logger.Info("Message received", "text", "hello there")
+	if !strings.Contains(buf.String(), `text="hello there"`) {
+		t.Errorf("message text must be logged by default: %s", buf.String())
+	}
+
+	logger, buf = newTestLogger(&Config{LogRedactMessages: true})
+	logger.Info("Message received", "text", "hello there", "chat", 1)
+	if out := buf.String(); strings.Contains(out, "hello") || !strings.Contains(out, `text="[11 chars]"`) || !strings.Contains(out, "chat=1") {
+		t.Errorf("unexpected log: %s", out)
+	}
+
+	logger, buf = newTestLogger(&Config{LogRedactMessages: true})
+	logger.Info("Tool call", "tool", "memory_add", "args", "map[text:likes cats]", "result", "Saved: likes cats")
+	if out := buf.String(); strings.Contains(out, "cats") || !strings.Contains(out, "tool=memory_add") {
+		t.Errorf("tool arguments and results must be hidden: %s", out)
+	}
+}
+
+func TestConfigLogValue(t *testing.T) {
+	config := &Config{BotToken: "secret-token", Model: "llama", Providers: map[string]ProviderConfig{"vllm": {APIKey: "sk-provider"}}}
+	logger, buf := newTestLogger(&Config{})
+
+	logger.Info("Config", "config", config)
+
+	out := buf.String()
+	if strings.Contains(out, "secret-token") || strings.Contains(out, "sk-provider") || !strings.Contains(out, "llama") {
+		t.Errorf("unexpected log: %s", out)
+	}
+	if config.BotToken != "secret-token" || config.Providers["vllm"].APIKey != "sk-provider" {
+		t.Errorf("config must not be changed")
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	logger, closer, err := newLogger(&Config{LogLevel: "warn", LogFormat: "json"})
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	if closer != nil {
+		t.Errorf("no closer expected without a log file")
+	}
+	if logger.Enabled(context.Background(), slog.LevelInfo) || !logger.Enabled(context.Background(), slog.LevelWarn) {
+		t.Errorf("unexpected level")
+	}
+
+	logger, _, _ = newLogger(&Config{EnableLog: true})
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("enableLog must turn on debug logs")
+	}
+
+	if _, _, err := newLogger(&Config{LogLevel: "verbose"}); err == nil {
+		t.Errorf("expected error for unknown level")
+	}
+	if _, _, err := newLogger(&Config{LogFormat: "xml"}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
 	"github.com/peterhellberg/giphy"
 	"gopkg.in/telebot.v3"
@@ -16,125 +18,249 @@ import (
 
 type bot struct {
 	tgBot        *telebot.Bot
-	config       *Config
-	chatContexts *ChatContext
-	llmChan      chan *data
+	config       *Config // config the bot started with, use cfg() to get the current one
+	liveConfig   atomic.Pointer[Config]
+	chatContexts *ChatContexts
+	queue        *llmQueue
 	startTime    time.Time
-	giphy 		*giphy.Client
+	giphy        *giphy.Client
+	ollama       *ollama.Client
+	providers    map[string]Provider
+	tools        *toolRegistry
+	vision       visionCache
+	commands     []*command
+	roles        roleCache
+	tokens       tokenEstimator
+	limiter      *rateLimiter
+	backlog      backlogTracker
+	updates      updateTracker
+
+	poller            *healthPoller
+	lastOllamaSuccess atomic.Int64
+
+	inflight     sync.WaitGroup // running telegram handlers
+	inflightMu   sync.Mutex
+	shuttingDown atomic.Bool
 }
 
 type data struct {
-	request  *ollama.ChatRequest
+	message  Message
+	request  *ollama.ChatRequest // built by the worker from message
 	ctx      telebot.Context
-	response chan string
+	chat     *ChatContext
+	role     Role
+	response chan llmReply
+	stream   *streamReply
+}
+
+// llmReply is the answer to a queued request, an empty reply without error is not sent
+type llmReply struct {
+	text string
+	err  error
 }
 
 func main() {
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// runBot starts the bot and serves until an interrupt signal
+func runBot(configFile string) {
+	// Config
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fatal(logMain, "Error loading config", err)
+	}
+
+	// Logs
+	logFile, err := setupLogging(config)
+	if err != nil {
+		fatal(logMain, "Error setting up logging", err)
+	}
 
-	configFile := ""
+	logMain.Info("Config loaded", "file", configFile)
+	logMain.Debug("Config", "config", config)
 
-	// Get config file from command line argument -c or use default
-	if len(os.Args) > 1 {
-		for i := range os.Args {
-			if os.Args[i] == "-c" && i+1 < len(os.Args) {
-				configFile = os.Args[i+1]
-				break
-			}
+	var store Store
+	if config.EnableSaveHistory {
+		store, err = newStore(config)
+		if err != nil {
+			fatal(logHistory, "Error opening storage", err)
 		}
 	}
 
-	// Load config from file or use default if not found
-	if configFile == "" {
-		configFile = "config.json"
+	// Continue from the last update handled before the restart
+	offset, err := newUpdateOffset(config.HistoryDir, configFile)
+	if err != nil {
+		fatal(logTelegram, "Error opening update offset", err)
+	}
+	lastUpdateID, err := offset.Load()
+	if err != nil {
+		logTelegram.Error("Error loading update offset", "error", err)
 	}
 
-	log.Printf("Config file: %v\n", configFile)
+	// Telegram bot, updates come by long polling unless a webhook is configured
+	var updates telebot.Poller = &telebot.LongPoller{
+		Timeout:      10 * time.Second,
+		LastUpdateID: lastUpdateID,
+	}
+	var webhook *webhookPoller
+	if config.WebhookURL != "" {
+		webhook = newWebhookPoller(config.WebhookSecret)
+		updates = webhook
+	}
+	poller := newHealthPoller(updates)
+	// Handlers run in the goroutines of dispatchUpdate, they must finish before the offset moves past them
+	tgBot, err := telebot.NewBot(telebot.Settings{
+		Token:       config.BotToken,
+		Poller:      poller,
+		Synchronous: true,
+	})
 
-	// Config
-	config, err := loadConfig(configFile)
 	if err != nil {
-		log.Fatal(err)
-		return
+		fatal(logTelegram, "Error creating bot", err)
 	}
 
-	// Logs
-	if config.EnableLog {
-		/* 		logFile, err := os.OpenFile("log.txt", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		   		if err != nil {
-		   			log.Fatalf("Error log file: %v", err)
-		   		}
-		   		defer logFile.Close()
-		   		multiWriter := io.MultiWriter(os.Stdout, logFile)
-		   		log.SetOutput(multiWriter) */
-		log.SetFlags(log.Ldate | log.Ltime)
+	var webhookServer *http.Server
+	if webhook != nil {
+		webhookServer, err = serveWebhook(config, webhook)
+		if err != nil {
+			fatal(logTelegram, "Error starting webhook listener", err)
+		}
+		if err = tgBot.SetWebhook(webhookSettings(config)); err != nil {
+			fatal(logTelegram, "Error setting webhook", err)
+		}
+	} else {
+		removeStaleWebhook(tgBot)
 	}
 
-	if config.EnableLog {
-		log.Printf("Config loaded:\n%s\n", spew.Sprintf("%+v", *config))
+	ollamaClient, err := newOllamaClient(config, config.ServerURL)
+	if err != nil {
+		fatal(logLLM, "Error creating ollama client", err)
 	}
 
-	// Telegram bot
-	tgBot, err := telebot.NewBot(telebot.Settings{
-		Token: config.BotToken,
-		Poller: &telebot.LongPoller{
-			Timeout:      10 * time.Second,
-			LastUpdateID: 0,
-		},
-	})
-
+	providers, err := newProviders(config, ollamaClient)
 	if err != nil {
-		log.Println(err)
-		return
+		fatal(logLLM, "Error creating providers", err)
 	}
 
-	chatContexts := NewChatContext(config.ChatGroupID, config.HistorySize, fmt.Sprintf("./%d_history.json", config.ChatGroupID), config.EnableSaveHistory)
+	chatContexts := NewChatContexts(config.HistorySize, store)
 
 	chatBot := &bot{
 		tgBot:        tgBot,
 		config:       config,
 		chatContexts: chatContexts,
-		llmChan:      make(chan *data, 1),
 		startTime:    time.Now(),
-		giphy: 		  giphy.NewClient(giphy.APIKey(config.GiphyAPIKey), giphy.Rating("r")),
+		giphy:        giphy.NewClient(giphy.APIKey(config.GiphyAPIKey), giphy.Rating("r")),
+		ollama:       ollamaClient,
+		providers:    providers,
+		tools:        newToolRegistry(),
+		poller:       poller,
+		limiter:      newRateLimiter(config.UserRateLimit, config.ChatRateLimit, time.Duration(config.RateLimitWindow)*time.Second),
 	}
 
+	poller.dispatch = chatBot.dispatchUpdate
+	registerBuiltinTools(chatBot.tools)
+	chatContexts.OnEvict(chatBot.onHistoryEvict)
+	chatBot.commands = chatBot.newCommands()
+
+	chatBot.queue = newLLMQueue(config.Workers, config.QueueSize, time.Duration(config.RequestTimeout)*time.Second, chatBot.processOllama)
+
 	// Handlers
 	handlers(tgBot, chatBot)
 
-	log.Println("ollama-telegram-bot running...")
-	go chatBot.processOllama()
+	// Monitoring
+	chatBot.registerMetrics()
+	var httpServer *http.Server
+	if config.HTTPListen != "" {
+		httpServer = &http.Server{Addr: config.HTTPListen, Handler: chatBot.newHTTPHandler()}
+		go func() {
+			logHTTP.Info("HTTP listening", "addr", config.HTTPListen)
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logHTTP.Error("HTTP server error", "error", err)
+			}
+		}()
+	}
+
+	logMain.Info("ollama-telegram-bot running...")
+	chatBot.queue.Start()
 
-	// Send hello to chat group
-	err = chatBot.SendMessageToChatGroup(config.ChatGroupID, config.GreetingMessage)
-	if err != nil {
-		log.Println(err)
+	// Save history in background
+	saveCtx, stopSaving := context.WithCancel(context.Background())
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		if store != nil {
+			chatContexts.Run(saveCtx, time.Duration(config.SaveInterval)*time.Second)
+		}
+	}()
+	offsetSaved := make(chan struct{})
+	go func() {
+		defer close(offsetSaved)
+		runOffsetSaver(saveCtx, offset, &chatBot.updates, time.Duration(config.SaveInterval)*time.Second)
+	}()
+
+	// Send hello to chat groups
+	for _, chatID := range config.AllowedChats {
+		err = chatBot.SendMessageToChatGroup(chatID, config.GreetingMessage)
+		if err != nil {
+			logTelegram.Error("Error sending greeting", "chat", chatID, "error", err)
+		}
 	}
 
+	// Reload prompts and other settings on SIGHUP
+	chatBot.watchReload(configFile)
+
 	// Handle interapt signal
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	go tgBot.Start()
+
+	s := <-sig
+	logMain.Info("Received interrupt signal, stopping bot", "signal", s.String())
+
+	// A second signal does not wait for requests to finish
 	go func() {
 		s := <-sig
-		log.Println("Received interrupt signal:", s)
-		log.Println("Stopping bot...")
+		logMain.Warn("Received second signal, exiting", "signal", s.String())
+		os.Exit(1)
+	}()
 
-		if config.EnableSaveHistory {
-			if err = chatContexts.SaveToFile(); err != nil {
-				log.Println(err)
-			}
+	chatBot.shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	if webhookServer != nil {
+		if err := webhookServer.Shutdown(ctx); err != nil {
+			logTelegram.Error("Error stopping webhook listener", "error", err)
+		}
+	}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logHTTP.Error("Error stopping HTTP server", "error", err)
 		}
+	}
+	cancel()
 
-		// Send goodbye to chat group
-		err = chatBot.SendMessageToChatGroup(config.ChatGroupID, config.GoodbyeMessage)
-		if err != nil {
-			log.Println(err)
+	// Final save happens when saving stops
+	stopSaving()
+	<-saved
+	<-offsetSaved
+	if store != nil {
+		if err = store.Close(); err != nil {
+			logHistory.Error("Error closing storage", "error", err)
 		}
+	}
 
-		tgBot.Stop()
-		log.Println("Bot stopped")
-		os.Exit(0)
-	}()
+	// Send goodbye to chat groups
+	for _, chatID := range chatBot.cfg().AllowedChats {
+		err = chatBot.SendMessageToChatGroup(chatID, chatBot.cfg().GoodbyeMessage)
+		if err != nil {
+			logTelegram.Error("Error sending goodbye", "chat", chatID, "error", err)
+		}
+	}
 
-	tgBot.Start()
+	logMain.Info("Bot stopped")
+	if logFile != nil {
+		logFile.Close()
+	}
 }
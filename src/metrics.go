@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "ollama_bot"
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	metricRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "LLM requests accepted per chat.",
+	}, []string{"chat"})
+
+	metricErrors = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Failed or rejected LLM requests by error type.",
+	}, []string{"type"})
+
+	metricRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to answer a request once a worker takes it, tool calls included.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"model"})
+
+	metricLLMDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_duration_seconds",
+		Help:      "Durations reported by ollama for one chat call by phase: total, load, prompt_eval, eval.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	}, []string{"model", "phase"})
+
+	metricTokens = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens processed by ollama by type: prompt, completion.",
+	}, []string{"model", "type"})
+
+	metricTokensPerSecond = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_tokens_per_second",
+		Help:      "Generation speed of one chat call.",
+		Buckets:   []float64{1, 2, 5, 10, 15, 20, 30, 50, 75, 100, 150},
+	}, []string{"model"})
+
+	metricBackendAnswers = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_answers_total",
+		Help:      "Chat requests answered by provider endpoint and model, fallbacks included.",
+	}, []string{"provider", "endpoint", "model"})
+
+	metricBackendFailures = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_failures_total",
+		Help:      "Failed chat requests by provider endpoint, they open the endpoint circuit breaker.",
+	}, []string{"provider", "endpoint"})
+
+	metricTelegramFailures = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "telegram_failures_total",
+		Help:      "Failed telegram api calls by method: send, edit, delete.",
+	}, []string{"method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// observeChat records durations and token counts reported by ollama
+func observeChat(model string, m ollama.Metrics) {
+	phases := map[string]int64{
+		"total":       m.TotalDuration,
+		"load":        m.LoadDuration,
+		"prompt_eval": m.PromptEvalDuration,
+		"eval":        m.EvalDuration,
+	}
+	for phase, d := range phases {
+		if d > 0 {
+			metricLLMDuration.WithLabelValues(model, phase).Observe(time.Duration(d).Seconds())
+		}
+	}
+
+	metricTokens.WithLabelValues(model, "prompt").Add(float64(m.PromptEvalCount))
+	metricTokens.WithLabelValues(model, "completion").Add(float64(m.EvalCount))
+
+	if m.EvalCount > 0 && m.EvalDuration > 0 {
+		metricTokensPerSecond.WithLabelValues(model).Observe(float64(m.EvalCount) / time.Duration(m.EvalDuration).Seconds())
+	}
+}
+
+// errorType returns the error label of a failed LLM request
+func errorType(err error) string {
+	var statusErr *ollama.StatusError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrShuttingDown):
+		return "shutdown"
+	case errors.Is(err, ollama.ErrModelNotFound):
+		return "model_not_found"
+	case errors.Is(err, ollama.ErrContextOverflow):
+		return "context_overflow"
+	case errors.Is(err, ollama.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ollama.ErrConnection):
+		return "connection"
+	case errors.Is(err, ollama.ErrServer):
+		return "server"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &statusErr):
+		return "status_" + strconv.Itoa(statusErr.StatusCode)
+	case errors.As(err, &netErr):
+		return "network"
+	default:
+		return "other"
+	}
+}
+
+// chatCollector reports sizes of chat histories and memories when metrics are scraped
+type chatCollector struct {
+	contexts *ChatContexts
+	history  *prometheus.Desc
+	memory   *prometheus.Desc
+}
+
+func newChatCollector(contexts *ChatContexts) *chatCollector {
+	return &chatCollector{
+		contexts: contexts,
+		history:  prometheus.NewDesc(metricsNamespace+"_history_messages", "Messages in the chat history.", []string{"chat"}, nil),
+		memory:   prometheus.NewDesc(metricsNamespace+"_memory_facts", "Facts in the chat memory.", []string{"chat"}, nil),
+	}
+}
+
+func (cc *chatCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.history
+	ch <- cc.memory
+}
+
+func (cc *chatCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, chat := range cc.contexts.All() {
+		id := strconv.FormatInt(chat.Chat, 10)
+		ch <- prometheus.MustNewConstMetric(cc.history, prometheus.GaugeValue, float64(len(chat.History.GetAll())), id)
+		ch <- prometheus.MustNewConstMetric(cc.memory, prometheus.GaugeValue, float64(len(chat.Memory.GetAll())), id)
+	}
+}
+
+// registerMetrics adds metrics that are read from the bot state
+func (b *bot) registerMetrics() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_depth",
+			Help:      "LLM requests waiting in the queue.",
+		}, func() float64 {
+			return float64(b.queue.Len())
+		}),
+		newChatCollector(b.chatContexts),
+	)
+}
+
+// newHTTPHandler returns the handler of the monitoring listener: metrics and health checks
+func (b *bot) newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", b.handleHealthz)
+	mux.HandleFunc("/readyz", b.handleReadyz)
+	return mux
+}
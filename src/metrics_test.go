@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := map[error]string{
+		context.DeadlineExceeded:                                                  "timeout",
+		fmt.Errorf("chat: %w", context.Canceled):                                  "canceled",
+		&ollama.StatusError{StatusCode: 404}:                                      "status_404",
+		fmt.Errorf("wrapped: %w", &ollama.StatusError{StatusCode: 500}):           "server",
+		&ollama.StatusError{StatusCode: 404, ErrorMessage: "model 'x' not found"}: "model_not_found",
+		fmt.Errorf("something"):                                                   "other",
+	}
+
+	for err, want := range tests {
+		if got := errorType(err); got != want {
+			t.Errorf("errorType(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	observeChat("test-model", ollama.Metrics{
+		TotalDuration:   int64(2e9),
+		PromptEvalCount: 100,
+		EvalCount:       50,
+		EvalDuration:    int64(1e9),
+	})
+
+	b := &bot{}
+	rec := httptest.NewRecorder()
+	b.newHTTPHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		`ollama_bot_llm_tokens_total{model="test-model",type="completion"}`,
+		`ollama_bot_llm_tokens_per_second_sum{model="test-model"} 50`,
+		`ollama_bot_llm_duration_seconds_count{model="test-model",phase="total"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics don't contain %s", want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxBackoff = 30 * time.Second
+
+// Client talks to the Ollama HTTP API
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+
+	retries int
+	backoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithTimeout sets the overall timeout of a single HTTP request, including reading a streamed body.
+// There is none by default, requests end with the deadline of their context
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.http.Timeout = timeout
+	}
+}
+
+// WithTransport replaces the HTTP transport used by the client
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		c.http.Transport = transport
+	}
+}
+
+// WithRetry sends a request up to retries more times when the server is not reachable or fails with 5xx.
+// The pause before a retry starts at backoff and doubles every time
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithHTTPClient uses a copy of httpClient, options applied after it change the copy only
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		hc := *httpClient
+		c.http = &hc
+	}
+}
+
+func NewClient(serverURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: invalid server url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("ollama: invalid server url: %q", serverURL)
+	}
+
+	c := &Client{
+		baseURL: u,
+		http:    &http.Client{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// BaseURL returns the server url the client was created with
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+// Chat sends a non-streaming request to /api/chat
+func (c *Client) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	r := *req
+	r.Stream = false
+
+	var resp ChatResponse
+	if err := c.do(ctx, http.MethodPost, "/api/chat", &r, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// ChatStream sends a streaming request to /api/chat, fn is called for every received chunk.
+// The returned response holds the whole message and the metrics of the final chunk
+func (c *Client) ChatStream(ctx context.Context, req *ChatRequest, fn func(*ChatResponse) error) (*ChatResponse, error) {
+	r := *req
+	r.Stream = true
+
+	resp, err := c.send(ctx, http.MethodPost, "/api/chat", &r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		result  ChatResponse
+		content strings.Builder
+	)
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			ChatResponse
+			Error string `json:"error"`
+		}
+
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("ollama: stream ended before done")
+			}
+			return nil, fmt.Errorf("ollama: decode stream: %w", Classify(err))
+		}
+
+		if chunk.Error != "" {
+			return nil, &StatusError{StatusCode: resp.StatusCode, ErrorMessage: chunk.Error}
+		}
+
+		content.WriteString(chunk.Message.Content)
+		toolCalls := append(result.Message.ToolCalls, chunk.Message.ToolCalls...)
+
+		result = chunk.ChatResponse
+		result.Message.Content = content.String()
+		result.Message.ToolCalls = toolCalls
+
+		if fn != nil {
+			if err := fn(&chunk.ChatResponse); err != nil {
+				return nil, err
+			}
+		}
+
+		if chunk.Done {
+			return &result, nil
+		}
+	}
+}
+
+// Generate sends a non-streaming request to /api/generate
+func (c *Client) Generate(ctx context.Context, req *GenerateRequest) (*GenerateResponse, error) {
+	r := *req
+	r.Stream = false
+
+	var resp GenerateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/generate", &r, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// Embed returns embeddings for every input string via /api/embed
+func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+	var resp EmbedResponse
+	if err := c.do(ctx, http.MethodPost, "/api/embed", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// List returns models available on the server
+func (c *Client) List(ctx context.Context) (*ListResponse, error) {
+	var resp ListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/tags", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// Show returns details of a model
+func (c *Client) Show(ctx context.Context, model string) (*ShowResponse, error) {
+	var resp ShowResponse
+	if err := c.do(ctx, http.MethodPost, "/api/show", map[string]string{"model": model}, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// Pull downloads a model and waits until the server reports success
+func (c *Client) Pull(ctx context.Context, model string) error {
+	req := map[string]any{"model": model, "stream": false}
+
+	var resp ProgressResponse
+	if err := c.do(ctx, http.MethodPost, "/api/pull", req, &resp); err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return fmt.Errorf("ollama: pull %s: unexpected status %q", model, resp.Status)
+	}
+
+	return nil
+}
+
+// Delete removes a model from the server
+func (c *Client) Delete(ctx context.Context, model string) error {
+	return c.do(ctx, http.MethodDelete, "/api/delete", map[string]string{"model": model}, nil)
+}
+
+// Version returns the Ollama server version
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var resp VersionResponse
+	if err := c.do(ctx, http.MethodGet, "/api/version", nil, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.Version, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, reqData, respData any) error {
+	resp, err := c.send(ctx, method, path, reqData)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("ollama: read response: %w", Classify(err))
+	}
+
+	if respData == nil || len(respBody) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, respData); err != nil {
+		return fmt.Errorf("ollama: decode response: %w", err)
+	}
+
+	return nil
+}
+
+// send makes a request, retrying transient failures. Responses with a non 2xx status are returned as errors
+func (c *Client) send(ctx context.Context, method, path string, reqData any) (*http.Response, error) {
+	var jsonData []byte
+	if reqData != nil {
+		var err error
+		jsonData, err = json.Marshal(reqData)
+		if err != nil {
+			return nil, fmt.Errorf("ollama: marshal request: %w", err)
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.sendOnce(ctx, method, path, jsonData)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, method, path string, jsonData []byte) (*http.Response, error) {
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.JoinPath(path).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: make request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ollama: %s %s: %w", method, path, Classify(err))
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, checkResponse(resp, respBody)
+	}
+
+	return resp, nil
+}
+
+func checkResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+
+	apiErr := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Error == "" {
+		apiErr.Error = strings.TrimSpace(string(body))
+	}
+
+	return &StatusError{
+		StatusCode:   resp.StatusCode,
+		Status:       resp.Status,
+		ErrorMessage: apiErr.Error,
+	}
+}
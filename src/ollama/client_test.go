@@ -0,0 +1,318 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestClientChat(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req.Stream {
+			t.Errorf("expected stream to be disabled")
+		}
+		if req.Model != "llama" || len(req.Messages) != 1 {
+			t.Errorf("unexpected request %+v", req)
+		}
+
+		io.WriteString(w, `{"model":"llama","message":{"role":"assistant","content":"hi"},"done":true,"prompt_eval_count":12,"eval_count":3}`)
+	})
+
+	resp, err := c.Chat(context.Background(), &ChatRequest{
+		Model:          "llama",
+		Messages:       []Message{MakeMessage("user", "hello")},
+		AdvancedParams: AdvancedParams{Stream: true},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Message.Content != "hi" || !resp.Done {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if resp.PromptEvalCount != 12 || resp.EvalCount != 3 {
+		t.Errorf("metrics not decoded: %+v", resp.Metrics)
+	}
+}
+
+func TestClientGenerate(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/generate" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		io.WriteString(w, `{"model":"llama","response":"42","done":true}`)
+	})
+
+	resp, err := c.Generate(context.Background(), &GenerateRequest{Model: "llama", Prompt: "answer"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "42" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestClientEmbed(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		io.WriteString(w, `{"model":"nomic","embeddings":[[0.1,0.2],[0.3,0.4]]}`)
+	})
+
+	resp, err := c.Embed(context.Background(), &EmbedRequest{Model: "nomic", Input: []string{"a", "b"}})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(resp.Embeddings) != 2 || resp.Embeddings[1][1] != 0.4 {
+		t.Errorf("unexpected embeddings %v", resp.Embeddings)
+	}
+}
+
+func TestClientModels(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/tags":
+			io.WriteString(w, `{"models":[{"name":"llama:latest","size":100}]}`)
+		case "POST /api/show":
+			io.WriteString(w, `{"capabilities":["completion","vision"],"details":{"family":"llama"}}`)
+		case "POST /api/pull":
+			io.WriteString(w, `{"status":"success"}`)
+		case "DELETE /api/delete":
+			w.WriteHeader(http.StatusOK)
+		case "GET /api/version":
+			io.WriteString(w, `{"version":"0.5.4"}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+
+	list, err := c.List(ctx)
+	if err != nil || len(list.Models) != 1 || list.Models[0].Name != "llama:latest" {
+		t.Errorf("List = %+v, %v", list, err)
+	}
+
+	show, err := c.Show(ctx, "llama")
+	if err != nil || len(show.Capabilities) != 2 || show.Details.Family != "llama" {
+		t.Errorf("Show = %+v, %v", show, err)
+	}
+
+	if err := c.Pull(ctx, "llama"); err != nil {
+		t.Errorf("Pull: %v", err)
+	}
+
+	if err := c.Delete(ctx, "llama"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+
+	version, err := c.Version(ctx)
+	if err != nil || version != "0.5.4" {
+		t.Errorf("Version = %q, %v", version, err)
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"model 'llama' not found"}`)
+	})
+
+	_, err := c.Chat(context.Background(), &ChatRequest{Model: "llama"})
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || statusErr.ErrorMessage != "model 'llama' not found" {
+		t.Errorf("unexpected error %+v", statusErr)
+	}
+	if !errors.Is(err, ErrModelNotFound) || errors.Is(err, ErrServer) {
+		t.Errorf("expected ErrModelNotFound, got %v", err)
+	}
+}
+
+func TestStatusErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  *StatusError
+		kind error
+	}{
+		{&StatusError{StatusCode: 404, ErrorMessage: `model "x" not found, try pulling it first`}, ErrModelNotFound},
+		{&StatusError{StatusCode: 400, ErrorMessage: "input length exceeds maximum context length"}, ErrContextOverflow},
+		{&StatusError{StatusCode: 502, Status: "502 Bad Gateway"}, ErrServer},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.kind) {
+			t.Errorf("%v is not %v", tt.err, tt.kind)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"version":"0.5.0"}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, WithRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if version, err := c.Version(context.Background()); err != nil || version != "0.5.0" || calls != 3 {
+		t.Errorf("Version = %q, %v after %d calls", version, err, calls)
+	}
+}
+
+func TestClientNoRetryOnClientError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"model 'llama' not found"}`)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := c.Show(context.Background(), "llama"); err == nil || calls != 1 {
+		t.Errorf("expected one failed call, got %d calls, %v", calls, err)
+	}
+}
+
+func TestClientConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	c, err := NewClient(url, WithRetry(1, time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := c.List(context.Background()); !errors.Is(err, ErrConnection) {
+		t.Errorf("expected ErrConnection, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, WithTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if _, err := c.Version(context.Background()); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestClientContextDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	httpClient := &http.Client{}
+	c, err := NewClient(srv.URL, WithHTTPClient(httpClient), WithTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if httpClient.Timeout != 0 {
+		t.Errorf("the caller's client must not be changed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Version(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
+
+func TestNewClientInvalidURL(t *testing.T) {
+	if _, err := NewClient("localhost"); err == nil {
+		t.Errorf("expected error for url without scheme")
+	}
+}
+
+func TestClientChatStream(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("expected stream to be enabled")
+		}
+
+		io.WriteString(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+		io.WriteString(w, `{"message":{"role":"assistant","content":"lo"},"done":false}`+"\n")
+		io.WriteString(w, `{"message":{"role":"assistant","content":""},"done":true,"eval_count":2}`+"\n")
+	})
+
+	var chunks []string
+	resp, err := c.ChatStream(context.Background(), &ChatRequest{Model: "llama"}, func(chunk *ChatResponse) error {
+		chunks = append(chunks, chunk.Message.Content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if len(chunks) != 3 || chunks[0] != "Hel" {
+		t.Errorf("unexpected chunks %q", chunks)
+	}
+	if resp.Message.Content != "Hello" || !resp.Done || resp.EvalCount != 2 {
+		t.Errorf("unexpected final response %+v", resp)
+	}
+}
+
+func TestClientChatStreamError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`+"\n")
+		io.WriteString(w, `{"error":"out of memory"}`+"\n")
+	})
+
+	_, err := c.ChatStream(context.Background(), &ChatRequest{Model: "llama"}, nil)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.ErrorMessage != "out of memory" {
+		t.Errorf("expected stream error, got %v", err)
+	}
+}
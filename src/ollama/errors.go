@@ -0,0 +1,99 @@
+package ollama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// Kinds of failures, match them with errors.Is
+var (
+	ErrConnection      = errors.New("ollama: server is not reachable")
+	ErrTimeout         = errors.New("ollama: request timed out")
+	ErrModelNotFound   = errors.New("ollama: model not found")
+	ErrContextOverflow = errors.New("ollama: input exceeds the context length")
+	ErrServer          = errors.New("ollama: server error")
+)
+
+// StatusError is returned when the server answers with a non 2xx status code
+type StatusError struct {
+	StatusCode   int
+	Status       string
+	ErrorMessage string
+}
+
+func (e *StatusError) Error() string {
+	switch {
+	case e.Status != "" && e.ErrorMessage != "":
+		return fmt.Sprintf("ollama: %s: %s", e.Status, e.ErrorMessage)
+	case e.ErrorMessage != "":
+		return fmt.Sprintf("ollama: %d: %s", e.StatusCode, e.ErrorMessage)
+	case e.Status != "":
+		return fmt.Sprintf("ollama: %s", e.Status)
+	default:
+		return fmt.Sprintf("ollama: unexpected status code %d", e.StatusCode)
+	}
+}
+
+// Is tells the kind of the failure by the status code and the server message
+func (e *StatusError) Is(target error) bool {
+	msg := strings.ToLower(e.ErrorMessage)
+
+	switch target {
+	case ErrModelNotFound:
+		return e.StatusCode == http.StatusNotFound && strings.Contains(msg, "not found")
+	case ErrContextOverflow:
+		return strings.Contains(msg, "context length") || strings.Contains(msg, "context window") ||
+			strings.Contains(msg, "prompt is too long")
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// kindError adds a failure kind to an error without changing its text
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// Classify marks transport errors as ErrTimeout or ErrConnection, other errors are returned as is
+func Classify(err error) error {
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return &kindError{kind: ErrTimeout, err: err}
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &dnsErr),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return &kindError{kind: ErrConnection, err: err}
+	}
+	return err
+}
+
+// retryable reports whether a request may succeed when sent again
+func retryable(err error) bool {
+	if errors.Is(err, ErrContextOverflow) {
+		return false
+	}
+	return errors.Is(err, ErrConnection) || errors.Is(err, ErrServer)
+}
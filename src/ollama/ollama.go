@@ -6,26 +6,25 @@ import (
 )
 
 type ChatRequest struct {
-	Model    string         `json:"model"`
-	Messages []Message      `json:"messages"`
-	Tools    []Tool         `json:"tools,omitempty"`
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Tools    []Tool    `json:"tools,omitempty"`
 	AdvancedParams
 }
 
 type Message struct {
-	Role      string     `json:"role"`
-	Content   string     `json:"content"`
-	Images    []Image    `json:"images,omitempty"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role      string      `json:"role"`
+	Content   string      `json:"content"`
+	Images    []ImageData `json:"images,omitempty"`
+	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
+	ToolName  string      `json:"tool_name,omitempty"`
 }
 
-type Image struct {
-	URL     string `json:"url"`
-	Caption string `json:"caption,omitempty"`
-}
+// ImageData is raw image bytes, encoded as base64 in JSON
+type ImageData []byte
 
 type ToolCall struct {
-	ID       string   `json:"id"`
+	ID       string   `json:"id,omitempty"`
 	Function Function `json:"function"`
 }
 
@@ -37,57 +36,169 @@ type Function struct {
 type Args map[string]interface{}
 
 type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
 	Name        string          `json:"name"`
 	Description string          `json:"description"`
 	Parameters  json.RawMessage `json:"parameters"`
 }
 
 type AdvancedParams struct {
-	Format    string  `json:"format,omitempty"`
+	Format    string   `json:"format,omitempty"`
 	Options   *Options `json:"options,omitempty"`
-	Stream    bool    `json:"stream"`
-	KeepAlive string  `json:"keep_alive,omitempty"`
+	Stream    bool     `json:"stream"`
+	KeepAlive string   `json:"keep_alive,omitempty"`
 }
 
+// Options are sent only when set, pointers keep zero values like temperature 0
 type Options struct {
-	Mirostat       int     `json:"mirostat,omitempty"`
-	MirostatEta    float64 `json:"mirostat_eta,omitempty"`
-	MirostatTau    float64 `json:"mirostat_tau,omitempty"`
-	NumCtx         int     `json:"num_ctx,omitempty"`
-	RepeatLastN    int     `json:"repeat_last_n,omitempty"`
-	RepeatPenalty  float64 `json:"repeat_penalty,omitempty"`
-	Temperature    float64 `json:"temperature,omitempty"`
-	Seed           int     `json:"seed,omitempty"`
-	Stop           string  `json:"stop,omitempty"`
-	TfsZ           float64 `json:"tfs_z,omitempty"`
-	NumPredict     int     `json:"num_predict,omitempty"`
-	TopK           int     `json:"top_k,omitempty"`
-	TopP           float64 `json:"top_p,omitempty"`
-	MinP           float64 `json:"min_p,omitempty"`
+	Mirostat      *int     `json:"mirostat,omitempty"`
+	MirostatEta   *float64 `json:"mirostat_eta,omitempty"`
+	MirostatTau   *float64 `json:"mirostat_tau,omitempty"`
+	NumCtx        *int     `json:"num_ctx,omitempty"`
+	RepeatLastN   *int     `json:"repeat_last_n,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	TfsZ          *float64 `json:"tfs_z,omitempty"`
+	NumPredict    *int     `json:"num_predict,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+}
+
+// Ptr returns a pointer to v, for setting Options fields
+func Ptr[T any](v T) *T {
+	return &v
 }
 
 // --------------------------------------------
 
 type ChatResponse struct {
-	Model              string    `json:"model"`
-	CreatedAt          time.Time `json:"created_at"`
-	Message            Message   `json:"message"`
-	Done               bool      `json:"done"`
-	TotalDuration      int64     `json:"total_duration"`
-	LoadDuration       int64     `json:"load_duration"`
-	PromptEvalCount    int       `json:"prompt_eval_count"`
-	PromptEvalDuration int64     `json:"prompt_eval_duration"`
-	EvalCount          int       `json:"eval_count"`
-	EvalDuration       int64     `json:"eval_duration"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   Message   `json:"message"`
+	Done      bool      `json:"done"`
+	Metrics
 }
 
+// Metrics are the timings and token counts Ollama reports with the final response
+type Metrics struct {
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalCount          int   `json:"eval_count"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
 
 // --------------------------------------------
 
+type GenerateRequest struct {
+	Model   string `json:"model"`
+	Prompt  string `json:"prompt"`
+	Suffix  string `json:"suffix,omitempty"`
+	System  string `json:"system,omitempty"`
+	Raw     bool   `json:"raw,omitempty"`
+	Context []int  `json:"context,omitempty"`
+	AdvancedParams
+}
+
+type GenerateResponse struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Response  string    `json:"response"`
+	Done      bool      `json:"done"`
+	Context   []int     `json:"context,omitempty"`
+	Metrics
+}
+
+// --------------------------------------------
+
+type EmbedRequest struct {
+	Model     string   `json:"model"`
+	Input     []string `json:"input"`
+	Truncate  *bool    `json:"truncate,omitempty"`
+	Options   *Options `json:"options,omitempty"`
+	KeepAlive string   `json:"keep_alive,omitempty"`
+}
+
+type EmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float32 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration"`
+	LoadDuration    int64       `json:"load_duration"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// --------------------------------------------
+
+type ListResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+type ModelInfo struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+type ModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+type ShowResponse struct {
+	License      string                 `json:"license,omitempty"`
+	Modelfile    string                 `json:"modelfile,omitempty"`
+	Parameters   string                 `json:"parameters,omitempty"`
+	Template     string                 `json:"template,omitempty"`
+	System       string                 `json:"system,omitempty"`
+	Details      ModelDetails           `json:"details"`
+	ModelInfo    map[string]interface{} `json:"model_info,omitempty"`
+	Capabilities []string               `json:"capabilities,omitempty"`
+	ModifiedAt   time.Time              `json:"modified_at"`
+}
+
+type ProgressResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
+// --------------------------------------------
 
 func MakeMessage(role, content string) Message {
 	return Message{
 		Role:    role,
 		Content: content,
 	}
-}
\ No newline at end of file
+}
+
+// MakeFunctionTool describes a function the model is allowed to call
+func MakeFunctionTool(name, description string, parameters json.RawMessage) Tool {
+	return Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}
+}
@@ -0,0 +1,354 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+// Client talks to an OpenAI compatible HTTP API
+type Client struct {
+	baseURL *url.URL
+	apiKey  string
+	http    *http.Client
+}
+
+type Option func(*Client)
+
+// WithAPIKey sends the key as a bearer token
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithTimeout sets the overall timeout of a single HTTP request, including reading a streamed body.
+// There is none by default, requests end with the deadline of their context
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.http.Timeout = timeout
+	}
+}
+
+// WithHTTPClient uses a copy of httpClient, options applied after it change the copy only
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		hc := *httpClient
+		c.http = &hc
+	}
+}
+
+// NewClient creates a client for the API root, usually ending with /v1
+func NewClient(serverURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("openai: invalid server url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("openai: invalid server url: %q", serverURL)
+	}
+
+	c := &Client{
+		baseURL: u,
+		http:    &http.Client{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// BaseURL returns the server url the client was created with
+func (c *Client) BaseURL() string {
+	return c.baseURL.String()
+}
+
+// Chat sends a non-streaming request to /chat/completions
+func (c *Client) Chat(ctx context.Context, req *ollama.ChatRequest) (*ollama.ChatResponse, error) {
+	r := toRequest(req)
+	r.Stream = false
+	r.StreamOptions = nil
+
+	resp, err := c.send(ctx, http.MethodPost, "chat/completions", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("openai: decode response: %w", ollama.Classify(err))
+	}
+	if len(data.Choices) == 0 {
+		return nil, fmt.Errorf("openai: response without choices")
+	}
+
+	msg, err := fromMessage(data.Choices[0].Message)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ollama.ChatResponse{
+		Model:     data.Model,
+		CreatedAt: time.Now(),
+		Message:   msg,
+		Done:      true,
+		Metrics:   data.Usage.metrics(),
+	}, nil
+}
+
+// ChatStream sends a streaming request and calls fn for every received chunk.
+// The returned response has the whole message, tool calls and token usage
+func (c *Client) ChatStream(ctx context.Context, req *ollama.ChatRequest, fn func(*ollama.ChatResponse) error) (*ollama.ChatResponse, error) {
+	r := toRequest(req)
+	r.Stream = true
+	r.StreamOptions = &streamOptions{IncludeUsage: true}
+
+	resp, err := c.send(ctx, http.MethodPost, "chat/completions", r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		model   string
+		role    string
+		content strings.Builder
+		metrics ollama.Metrics
+		calls   = make(map[int]*toolCall)
+		done    bool
+	)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("openai: decode stream: %w", err)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			metrics = chunk.Usage.metrics()
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Role != "" {
+			role = delta.Role
+		}
+		content.WriteString(delta.Content)
+
+		// Tool calls come in pieces, arguments are split between chunks
+		for i, call := range delta.ToolCalls {
+			index := i
+			if call.Index != nil {
+				index = *call.Index
+			}
+			acc, ok := calls[index]
+			if !ok {
+				acc = &toolCall{}
+				calls[index] = acc
+			}
+			if call.ID != "" {
+				acc.ID = call.ID
+			}
+			if call.Function.Name != "" {
+				acc.Function.Name = call.Function.Name
+			}
+			acc.Function.Arguments += call.Function.Arguments
+		}
+
+		if fn != nil && delta.Content != "" {
+			err := fn(&ollama.ChatResponse{
+				Model:   model,
+				Message: ollama.Message{Role: "assistant", Content: delta.Content},
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("openai: read stream: %w", ollama.Classify(err))
+	}
+	// A body cut by a proxy or a crashed server ends without [DONE]
+	if !done {
+		return nil, fmt.Errorf("openai: stream ended before [DONE]: %w", ollama.ErrConnection)
+	}
+
+	indexes := make([]int, 0, len(calls))
+	for index := range calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	final := responseMessage{Role: role, Content: content.String()}
+	for _, index := range indexes {
+		final.ToolCalls = append(final.ToolCalls, *calls[index])
+	}
+
+	msg, err := fromMessage(final)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ollama.ChatResponse{
+		Model:     model,
+		CreatedAt: time.Now(),
+		Message:   msg,
+		Done:      true,
+		Metrics:   metrics,
+	}
+
+	if fn != nil {
+		if err := fn(&ollama.ChatResponse{Model: model, Message: ollama.Message{Role: msg.Role}, Done: true, Metrics: metrics}); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Models returns ids of the models served by /models
+func (c *Client) Models(ctx context.Context) ([]string, error) {
+	resp, err := c.send(ctx, http.MethodGet, "models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data modelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("openai: decode response: %w", err)
+	}
+
+	models := make([]string, 0, len(data.Data))
+	for _, m := range data.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// send makes a request, responses with a non 2xx status are returned as errors
+func (c *Client) send(ctx context.Context, method, path string, reqData any) (*http.Response, error) {
+	var body io.Reader
+	if reqData != nil {
+		jsonData, err := json.Marshal(reqData)
+		if err != nil {
+			return nil, fmt.Errorf("openai: marshal request: %w", err)
+		}
+		body = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.JoinPath(path).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("openai: make request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openai: %s %s: %w", method, path, ollama.Classify(err))
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp, respBody)
+	}
+
+	return resp, nil
+}
+
+// StatusError is returned when the server answers with a non 2xx status code.
+// It matches the ollama error kinds with errors.Is
+type StatusError struct {
+	StatusCode   int
+	Status       string
+	Code         string
+	ErrorMessage string
+}
+
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+
+	var apiErr struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err == nil && len(apiErr.Error) > 0 {
+		var detail struct {
+			Message string `json:"message"`
+			Code    any    `json:"code"`
+		}
+		if json.Unmarshal(apiErr.Error, &detail) == nil {
+			e.ErrorMessage = detail.Message
+			if detail.Code != nil {
+				e.Code = fmt.Sprint(detail.Code)
+			}
+		} else {
+			json.Unmarshal(apiErr.Error, &e.ErrorMessage)
+		}
+	}
+	if e.ErrorMessage == "" {
+		e.ErrorMessage = strings.TrimSpace(string(body))
+	}
+
+	return e
+}
+
+func (e *StatusError) Error() string {
+	if e.ErrorMessage != "" {
+		return fmt.Sprintf("openai: %s: %s", e.Status, e.ErrorMessage)
+	}
+	return fmt.Sprintf("openai: %s", e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	msg := strings.ToLower(e.ErrorMessage)
+
+	switch target {
+	case ollama.ErrModelNotFound:
+		// Other 404s are wrong urls, trying another model would not help
+		return e.Code == "model_not_found" || e.StatusCode == http.StatusNotFound && strings.Contains(msg, "model") &&
+			(strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist"))
+	case ollama.ErrContextOverflow:
+		return e.Code == "context_length_exceeded" || strings.Contains(msg, "context length") ||
+			strings.Contains(msg, "context size") || strings.Contains(msg, "maximum context")
+	case ollama.ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
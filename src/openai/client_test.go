@@ -0,0 +1,237 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL+"/v1", WithAPIKey("secret"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c
+}
+
+func TestClientChat(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("unexpected authorization %q", got)
+		}
+
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req["model"] != "llama" || req["temperature"] != 0.5 || req["stream"] != false {
+			t.Errorf("unexpected request %v", req)
+		}
+		if stop, _ := req["stop"].([]any); len(stop) != 2 || stop[1] != "Assistant:" {
+			t.Errorf("unexpected stop %v", req["stop"])
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"model": "llama",
+			"choices": []any{map[string]any{
+				"message":       map[string]any{"role": "assistant", "content": "hi"},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]any{"prompt_tokens": 10, "completion_tokens": 3},
+		})
+	})
+
+	resp, err := c.Chat(context.Background(), &ollama.ChatRequest{
+		Model:    "llama",
+		Messages: []ollama.Message{ollama.MakeMessage("user", "hello")},
+		AdvancedParams: ollama.AdvancedParams{
+			Options: &ollama.Options{Temperature: ollama.Ptr(0.5), Stop: []string{"User:", "Assistant:"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if resp.Message.Content != "hi" || !resp.Done {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if resp.PromptEvalCount != 10 || resp.EvalCount != 3 {
+		t.Errorf("unexpected usage %+v", resp.Metrics)
+	}
+}
+
+func TestToRequestImagesAndTools(t *testing.T) {
+	req := &ollama.ChatRequest{
+		Model: "llava",
+		Messages: []ollama.Message{
+			{Role: "user", Content: "what is it?", Images: []ollama.ImageData{[]byte("\x89PNG\r\n\x1a\n")}},
+			{Role: "assistant", ToolCalls: []ollama.ToolCall{
+				{Function: ollama.Function{Name: "current_time", Args: ollama.Args{}}},
+				{Function: ollama.Function{Name: "memory_add", Args: ollama.Args{"text": "cats"}}},
+			}},
+			{Role: "tool", Content: "noon"},
+			{Role: "tool", Content: "Remembered"},
+		},
+	}
+
+	r := toRequest(req)
+
+	parts, ok := r.Messages[0].Content.([]contentPart)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("expected text and image parts, got %#v", r.Messages[0].Content)
+	}
+	if parts[0].Text != "what is it?" || !strings.HasPrefix(parts[1].ImageURL.URL, "data:image/png;base64,") {
+		t.Errorf("unexpected parts %+v %+v", parts[0], parts[1].ImageURL)
+	}
+
+	calls := r.Messages[1].ToolCalls
+	if len(calls) != 2 || calls[1].Function.Name != "memory_add" || calls[1].Function.Arguments != `{"text":"cats"}` {
+		t.Fatalf("unexpected tool calls %+v", calls)
+	}
+	if r.Messages[2].ToolCallID != calls[0].ID || r.Messages[3].ToolCallID != calls[1].ID {
+		t.Errorf("tool results are not linked to calls: %q %q", r.Messages[2].ToolCallID, r.Messages[3].ToolCallID)
+	}
+}
+
+func TestClientChatStream(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("expected a stream with usage")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"model":"llama","choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"model":"llama","choices":[{"delta":{"content":"lo"}}]}`,
+			`{"model":"llama","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"memory_add","arguments":"{\"te"}}]}}]}`,
+			`{"model":"llama","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"xt\":\"cats\"}"}}]}}]}`,
+			`{"model":"llama","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":2}}`,
+			`[DONE]`,
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+	})
+
+	var text strings.Builder
+	var done int
+	resp, err := c.ChatStream(context.Background(), &ollama.ChatRequest{Model: "llama"}, func(chunk *ollama.ChatResponse) error {
+		text.WriteString(chunk.Message.Content)
+		if chunk.Done {
+			done++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+
+	if text.String() != "Hello" || done != 1 {
+		t.Errorf("unexpected chunks %q, done %d", text.String(), done)
+	}
+	if resp.Message.Content != "Hello" || resp.EvalCount != 2 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(resp.Message.ToolCalls) != 1 || resp.Message.ToolCalls[0].Function.Args["text"] != "cats" {
+		t.Errorf("unexpected tool calls %+v", resp.Message.ToolCalls)
+	}
+}
+
+func TestClientModels(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"data":[{"id":"llama"},{"id":"qwen"}]}`))
+	})
+
+	models, err := c.Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models: %v", err)
+	}
+	if len(models) != 2 || models[0] != "llama" || models[1] != "qwen" {
+		t.Errorf("unexpected models %v", models)
+	}
+}
+
+func TestClientErrorKinds(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		kind   error
+	}{
+		{http.StatusNotFound, `{"error":{"message":"The model does not exist","code":"model_not_found"}}`, ollama.ErrModelNotFound},
+		{http.StatusNotFound, `{"error":{"message":"model 'llama' not found"}}`, ollama.ErrModelNotFound},
+		{http.StatusBadRequest, `{"error":{"message":"too long","code":"context_length_exceeded"}}`, ollama.ErrContextOverflow},
+		{http.StatusBadRequest, `{"error":"the request exceeds the available context size"}`, ollama.ErrContextOverflow},
+		{http.StatusServiceUnavailable, `loading model`, ollama.ErrServer},
+	}
+
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		})
+
+		_, err := c.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"})
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("%d %s: expected %v, got %v", tt.status, tt.body, tt.kind, err)
+		}
+	}
+}
+
+func TestClientWrongURLNotModelError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	_, err := c.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"})
+	if err == nil || errors.Is(err, ollama.ErrModelNotFound) {
+		t.Errorf("a 404 of a wrong url is not a missing model: %v", err)
+	}
+}
+
+func TestClientChatStreamTruncated(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", `{"model":"llama","choices":[{"delta":{"role":"assistant","content":"Hel"}}]}`)
+	})
+
+	_, err := c.ChatStream(context.Background(), &ollama.ChatRequest{Model: "llama"}, nil)
+	if !errors.Is(err, ollama.ErrConnection) {
+		t.Errorf("expected connection error, got %v", err)
+	}
+}
+
+func TestClientConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	c, err := NewClient(srv.URL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	_, err = c.Chat(context.Background(), &ollama.ChatRequest{Model: "llama"})
+	if !errors.Is(err, ollama.ErrConnection) {
+		t.Errorf("expected connection error, got %v", err)
+	}
+}
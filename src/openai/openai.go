@@ -0,0 +1,190 @@
+// Package openai talks to OpenAI compatible /v1/chat/completions servers (llama.cpp server, vLLM, LM Studio).
+// Requests and responses use the ollama package types, so the bot can switch backends without changes
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+type chatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []message      `json:"messages"`
+	Tools         []ollama.Tool  `json:"tools,omitempty"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+
+	// Extensions of llama.cpp and vLLM
+	TopK          *int     `json:"top_k,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type message struct {
+	Role       string     `json:"role"`
+	Content    any        `json:"content"` // string or []contentPart
+	ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type toolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type chatResponse struct {
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   *usage   `json:"usage"`
+}
+
+type choice struct {
+	Message      responseMessage `json:"message"`
+	Delta        responseMessage `json:"delta"`
+	FinishReason string          `json:"finish_reason"`
+}
+
+type responseMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type modelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// toRequest converts an ollama chat request. Ollama tool results have no call ids,
+// they follow the assistant message in the order of its calls, so ids are assigned by position
+func toRequest(req *ollama.ChatRequest) *chatRequest {
+	r := &chatRequest{
+		Model:  req.Model,
+		Tools:  req.Tools,
+		Stream: req.Stream,
+	}
+	if req.Stream {
+		r.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+
+	if o := req.Options; o != nil {
+		r.Temperature = o.Temperature
+		r.TopP = o.TopP
+		r.MaxTokens = o.NumPredict
+		r.Seed = o.Seed
+		r.TopK = o.TopK
+		r.MinP = o.MinP
+		r.RepeatPenalty = o.RepeatPenalty
+		r.Stop = o.Stop
+	}
+
+	var pending []string
+	for i, msg := range req.Messages {
+		m := message{Role: msg.Role, Content: msg.Content}
+
+		if len(msg.Images) > 0 {
+			parts := []contentPart{{Type: "text", Text: msg.Content}}
+			for _, img := range msg.Images {
+				parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: dataURL(img)}})
+			}
+			m.Content = parts
+		}
+
+		if len(msg.ToolCalls) > 0 {
+			pending = pending[:0]
+			for j, call := range msg.ToolCalls {
+				id := call.ID
+				if id == "" {
+					id = fmt.Sprintf("call_%d_%d", i, j)
+				}
+				args, _ := json.Marshal(call.Function.Args)
+				m.ToolCalls = append(m.ToolCalls, toolCall{
+					ID:       id,
+					Type:     "function",
+					Function: functionCall{Name: call.Function.Name, Arguments: string(args)},
+				})
+				pending = append(pending, id)
+			}
+		}
+
+		if msg.Role == "tool" && len(pending) > 0 {
+			m.ToolCallID = pending[0]
+			pending = pending[1:]
+		}
+
+		r.Messages = append(r.Messages, m)
+	}
+
+	return r
+}
+
+// fromMessage converts a response message, tool arguments come as a json string
+func fromMessage(msg responseMessage) (ollama.Message, error) {
+	m := ollama.Message{Role: msg.Role, Content: msg.Content}
+	if m.Role == "" {
+		m.Role = "assistant"
+	}
+
+	for _, call := range msg.ToolCalls {
+		args := ollama.Args{}
+		if call.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+				return m, fmt.Errorf("openai: arguments of %s: %w", call.Function.Name, err)
+			}
+		}
+		m.ToolCalls = append(m.ToolCalls, ollama.ToolCall{
+			ID:       call.ID,
+			Function: ollama.Function{Name: call.Function.Name, Args: args},
+		})
+	}
+
+	return m, nil
+}
+
+func (u *usage) metrics() ollama.Metrics {
+	if u == nil {
+		return ollama.Metrics{}
+	}
+	return ollama.Metrics{PromptEvalCount: u.PromptTokens, EvalCount: u.CompletionTokens}
+}
+
+func dataURL(img ollama.ImageData) string {
+	return fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(img), base64.StdEncoding.EncodeToString(img))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+	"github.com/jeromeberg/ollama-telegram-bot/src/openai"
+)
+
+const (
+	providerOllama = "ollama"
+	providerOpenAI = "openai"
+)
+
+// Provider is an LLM backend, requests and responses use the ollama types
+type Provider interface {
+	Chat(ctx context.Context, req *ollama.ChatRequest) (*ollama.ChatResponse, error)
+	ChatStream(ctx context.Context, req *ollama.ChatRequest, fn func(*ollama.ChatResponse) error) (*ollama.ChatResponse, error)
+	Models(ctx context.Context) ([]string, error)
+	SupportsVision(ctx context.Context, model string) (bool, error)
+}
+
+// ollamaProvider serves requests with the ollama /api/chat endpoint
+type ollamaProvider struct {
+	*ollama.Client
+}
+
+func (p ollamaProvider) Models(ctx context.Context) ([]string, error) {
+	list, err := p.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(list.Models))
+	for _, model := range list.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// SupportsVision asks ollama for the model capabilities
+func (p ollamaProvider) SupportsVision(ctx context.Context, model string) (bool, error) {
+	show, err := p.Show(ctx, model)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(show.Capabilities, "vision") ||
+		slices.Contains(show.Details.Families, "clip") ||
+		slices.Contains(show.Details.Families, "mllama"), nil
+}
+
+// openaiProvider serves requests with an OpenAI compatible /v1/chat/completions endpoint
+type openaiProvider struct {
+	*openai.Client
+	vision bool
+}
+
+// SupportsVision returns the configured value, the API does not report model capabilities
+func (p openaiProvider) SupportsVision(ctx context.Context, model string) (bool, error) {
+	return p.vision, nil
+}
+
+// newOllamaClient creates an ollama client with the configured retries
+func newOllamaClient(config *Config, url string) (*ollama.Client, error) {
+	return ollama.NewClient(url, ollama.WithRetry(config.Retries, time.Duration(config.RetryBackoff)*time.Millisecond))
+}
+
+// newEndpoint creates a client of the provider type for one server
+func newEndpoint(config *Config, pc ProviderConfig, url string) (Provider, error) {
+	switch pc.Type {
+	case "", providerOllama:
+		client, err := newOllamaClient(config, url)
+		if err != nil {
+			return nil, err
+		}
+		return ollamaProvider{client}, nil
+	case providerOpenAI:
+		client, err := openai.NewClient(url, openai.WithAPIKey(pc.APIKey))
+		if err != nil {
+			return nil, err
+		}
+		return openaiProvider{Client: client, vision: pc.Vision}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", pc.Type)
+	}
+}
+
+// newProvider creates the provider, servers and models with fallbacks are served through failover
+func newProvider(config *Config, name string, pc ProviderConfig, primary Provider) (Provider, error) {
+	var err error
+	if primary == nil {
+		if primary, err = newEndpoint(config, pc, pc.URL); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+	}
+	if len(pc.FallbackURLs) == 0 && len(pc.FallbackModels) == 0 {
+		return primary, nil
+	}
+
+	threshold := config.BreakerThreshold
+	cooldown := time.Duration(config.BreakerCooldown) * time.Second
+
+	p := &failoverProvider{
+		name:      name,
+		endpoints: []*endpoint{{url: pc.URL, provider: primary, breaker: newBreaker(threshold, cooldown)}},
+		models:    pc.FallbackModels,
+		ollama:    pc.Type == "" || pc.Type == providerOllama,
+	}
+	for _, url := range pc.FallbackURLs {
+		ep, err := newEndpoint(config, pc, url)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+		p.endpoints = append(p.endpoints, &endpoint{url: url, provider: ep, breaker: newBreaker(threshold, cooldown)})
+	}
+
+	return p, nil
+}
+
+// newProviders creates the configured providers, the default ollama provider uses serverUrl and its fallbacks
+func newProviders(config *Config, ollamaClient *ollama.Client) (map[string]Provider, error) {
+	defaultProvider, err := newProvider(config, providerOllama, ProviderConfig{
+		Type:           providerOllama,
+		URL:            config.ServerURL,
+		FallbackURLs:   config.FallbackServers,
+		FallbackModels: config.FallbackModels,
+	}, ollamaProvider{ollamaClient})
+	if err != nil {
+		return nil, err
+	}
+
+	providers := map[string]Provider{
+		providerOllama: defaultProvider,
+	}
+
+	for name, pc := range config.Providers {
+		if providers[name], err = newProvider(config, name, pc, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for chatID, name := range config.ChatProviders {
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("chat %d uses unknown provider %s", chatID, name)
+		}
+	}
+	if name := config.DefaultProvider; name != "" {
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("unknown default provider %s", name)
+		}
+	}
+
+	return providers, nil
+}
+
+// chatProviderName returns the name of the provider that serves the chat
+func (b *bot) chatProviderName(chat *ChatContext) string {
+	if name, ok := b.cfg().ChatProviders[chat.Chat]; ok {
+		return name
+	}
+	return b.defaultProviderName()
+}
+
+// defaultProviderName returns the name of the provider that serves chats without their own provider
+func (b *bot) defaultProviderName() string {
+	if b.cfg().DefaultProvider != "" {
+		return b.cfg().DefaultProvider
+	}
+	return providerOllama
+}
+
+// chatProvider returns the provider that serves the chat
+func (b *bot) chatProvider(chat *ChatContext) Provider {
+	if p, ok := b.providers[b.chatProviderName(chat)]; ok {
+		return p
+	}
+	return ollamaProvider{b.ollama}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+func TestNewProviders(t *testing.T) {
+	client, err := ollama.NewClient("http://localhost:11434")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		Providers: map[string]ProviderConfig{
+			"llamacpp": {Type: "openai", URL: "http://localhost:8080/v1", Vision: true},
+			"gpu":      {URL: "http://gpu:11434", FallbackURLs: []string{"http://cpu:11434"}},
+		},
+		ChatProviders: map[int64]string{1: "llamacpp"},
+	}
+
+	providers, err := newProviders(config, client)
+	if err != nil {
+		t.Fatalf("newProviders: %v", err)
+	}
+	if _, ok := providers["ollama"].(ollamaProvider); !ok {
+		t.Errorf("expected the default ollama provider")
+	}
+	if p, ok := providers["llamacpp"].(openaiProvider); !ok || !p.vision {
+		t.Errorf("expected an openai provider with vision, got %#v", providers["llamacpp"])
+	}
+	if p, ok := providers["gpu"].(*failoverProvider); !ok || len(p.endpoints) != 2 || !isOllama(p) {
+		t.Errorf("expected an ollama failover provider, got %#v", providers["gpu"])
+	}
+
+	config.ChatProviders[2] = "missing"
+	if _, err := newProviders(config, client); err == nil {
+		t.Errorf("expected error for an unknown chat provider")
+	}
+
+	config.ChatProviders = nil
+	config.Providers["bad"] = ProviderConfig{Type: "anthropic", URL: "http://localhost"}
+	if _, err := newProviders(config, client); err == nil {
+		t.Errorf("expected error for an unknown provider type")
+	}
+}
+
+func TestChatProvider(t *testing.T) {
+	b := &bot{config: &Config{
+		Model:           "llama",
+		DefaultProvider: "gpu",
+		Providers: map[string]ProviderConfig{
+			"llamacpp": {Type: "openai", Model: "qwen"},
+			"gpu":      {},
+		},
+		ChatProviders: map[int64]string{1: "llamacpp"},
+	}}
+
+	chat := NewChatContext(1, 10)
+	if b.chatProviderName(chat) != "llamacpp" || b.chatModel(chat) != "qwen" {
+		t.Errorf("unexpected provider %s and model %s", b.chatProviderName(chat), b.chatModel(chat))
+	}
+
+	if err := chat.Settings.Set(settingModel, "mistral"); err != nil {
+		t.Fatal(err)
+	}
+	if b.chatModel(chat) != "mistral" {
+		t.Errorf("chat model must override the provider model, got %s", b.chatModel(chat))
+	}
+
+	other := NewChatContext(2, 10)
+	if b.chatProviderName(other) != "gpu" || b.chatModel(other) != "llama" {
+		t.Errorf("unexpected provider %s and model %s", b.chatProviderName(other), b.chatModel(other))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers         = 2
+	defaultQueueSize       = 10
+	defaultRequestTimeout  = 5 * time.Minute
+	defaultShutdownTimeout = time.Minute
+)
+
+var (
+	ErrQueueFull    = errors.New("llm queue is full")
+	ErrShuttingDown = errors.New("bot is shutting down")
+)
+
+// llmQueue runs requests on a fixed number of workers.
+// Requests of one chat are processed one by one in the order they were submitted,
+// different chats are processed in parallel
+type llmQueue struct {
+	mu      sync.Mutex
+	lanes   map[int64][]*data
+	ready   chan int64
+	pending int
+	size    int
+	closed  bool
+
+	drained   chan struct{} // closed when the queue is closed and empty
+	drainOnce sync.Once
+
+	workers int
+	timeout time.Duration
+	process func(ctx context.Context, d *data)
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+}
+
+func newLLMQueue(workers, size int, timeout time.Duration, process func(ctx context.Context, d *data)) *llmQueue {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &llmQueue{
+		lanes:   make(map[int64][]*data),
+		ready:   make(chan int64, size),
+		drained: make(chan struct{}),
+		size:    size,
+		workers: workers,
+		timeout: timeout,
+		process: process,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (q *llmQueue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Cap returns the maximum number of pending requests
+func (q *llmQueue) Cap() int {
+	return q.size
+}
+
+// Submit queues a request, ErrQueueFull is returned when there are too many pending requests
+func (q *llmQueue) Submit(d *data) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.ctx.Err() != nil {
+		return ErrShuttingDown
+	}
+
+	if q.pending >= q.size {
+		return ErrQueueFull
+	}
+
+	chatID := d.chat.Chat
+	q.lanes[chatID] = append(q.lanes[chatID], d)
+	q.pending++
+
+	// The chat is already waiting in ready or being processed otherwise
+	if len(q.lanes[chatID]) == 1 {
+		q.ready <- chatID
+	}
+
+	return nil
+}
+
+// Len returns the number of queued and in-flight requests
+func (q *llmQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pending
+}
+
+// Drain stops accepting requests and waits until queued and in-flight ones are done or ctx ends
+func (q *llmQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		if q.pending == 0 {
+			q.markDrained()
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop cancels in-flight requests, waits for workers and answers still queued requests with ErrShuttingDown
+func (q *llmQueue) Stop() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.cancel(ErrShuttingDown)
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for chatID, lane := range q.lanes {
+		for _, d := range lane {
+			d.response <- llmReply{err: ErrShuttingDown}
+		}
+		delete(q.lanes, chatID)
+	}
+	q.pending = 0
+	q.markDrained()
+}
+
+func (q *llmQueue) markDrained() {
+	q.drainOnce.Do(func() { close(q.drained) })
+}
+
+func (q *llmQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case chatID := <-q.ready:
+			// Stop answers requests left in the lanes
+			if q.ctx.Err() != nil {
+				return
+			}
+			q.run(chatID)
+		}
+	}
+}
+
+func (q *llmQueue) run(chatID int64) {
+	q.mu.Lock()
+	d := q.lanes[chatID][0]
+	q.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(q.ctx, q.timeout)
+	q.process(ctx, d)
+	cancel()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lanes[chatID] = q.lanes[chatID][1:]
+	q.pending--
+
+	if q.closed && q.pending == 0 {
+		q.markDrained()
+	}
+
+	if len(q.lanes[chatID]) == 0 {
+		delete(q.lanes, chatID)
+		return
+	}
+
+	q.ready <- chatID
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestData(chatID int64) *data {
+	return &data{
+		chat:     &ChatContext{Chat: chatID},
+		response: make(chan llmReply, 1),
+	}
+}
+
+func TestLLMQueueKeepsChatOrder(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seq  = map[*data]int{}
+		done []int
+	)
+
+	// Later jobs are faster, jobs of chat 1 must still finish in the order they came
+	q := newLLMQueue(3, 10, time.Second, func(ctx context.Context, d *data) {
+		mu.Lock()
+		n := seq[d]
+		mu.Unlock()
+
+		time.Sleep(time.Duration(10-n) * time.Millisecond)
+		mu.Lock()
+		if d.chat.Chat == 1 {
+			done = append(done, n)
+		}
+		mu.Unlock()
+		d.response <- llmReply{text: "ok"}
+	})
+	q.Start()
+	defer q.Stop()
+
+	chats := []int64{1, 1, 2, 1, 2, 1}
+	jobs := make([]*data, len(chats))
+	for i, chatID := range chats {
+		jobs[i] = newTestData(chatID)
+		mu.Lock()
+		seq[jobs[i]] = i
+		mu.Unlock()
+		if err := q.Submit(jobs[i]); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	for _, d := range jobs {
+		<-d.response
+	}
+
+	if !slices.Equal(done, []int{0, 1, 3, 5}) {
+		t.Errorf("chat 1 jobs finished in order %v", done)
+	}
+}
+
+func TestLLMQueueSerializesChat(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running = map[int64]int{}
+		overlap bool
+	)
+
+	q := newLLMQueue(4, 10, time.Second, func(ctx context.Context, d *data) {
+		mu.Lock()
+		running[d.chat.Chat]++
+		if running[d.chat.Chat] > 1 {
+			overlap = true
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running[d.chat.Chat]--
+		mu.Unlock()
+		d.response <- llmReply{text: "ok"}
+	})
+	q.Start()
+	defer q.Stop()
+
+	var jobs []*data
+	for i := 0; i < 8; i++ {
+		d := newTestData(int64(i % 2))
+		jobs = append(jobs, d)
+		if err := q.Submit(d); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	for _, d := range jobs {
+		<-d.response
+	}
+
+	if overlap {
+		t.Errorf("requests of one chat ran in parallel")
+	}
+}
+
+func TestLLMQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	q := newLLMQueue(1, 2, time.Second, func(ctx context.Context, d *data) {
+		<-block
+		d.response <- llmReply{text: "ok"}
+	})
+	q.Start()
+
+	q.Submit(newTestData(1))
+	q.Submit(newTestData(2))
+
+	if err := q.Submit(newTestData(3)); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("expected 2 pending requests, got %d", q.Len())
+	}
+
+	close(block)
+	q.Stop()
+}
+
+func TestLLMQueueTimeoutAndStop(t *testing.T) {
+	q := newLLMQueue(1, 10, 20*time.Millisecond, func(ctx context.Context, d *data) {
+		<-ctx.Done()
+		d.response <- llmReply{}
+	})
+	q.Start()
+
+	first := newTestData(1)
+	second := newTestData(2)
+	q.Submit(first)
+	q.Submit(second)
+
+	// First request is cancelled by the timeout
+	select {
+	case <-first.response:
+	case <-time.After(time.Second):
+		t.Fatalf("request was not cancelled by timeout")
+	}
+
+	q.Stop()
+
+	select {
+	case <-second.response:
+	case <-time.After(time.Second):
+		t.Fatalf("queued request was not answered on stop")
+	}
+
+	if err := q.Submit(newTestData(3)); err == nil {
+		t.Errorf("expected error after stop")
+	}
+}
+
+func TestLLMQueueDrain(t *testing.T) {
+	release := make(chan struct{})
+	q := newLLMQueue(1, 10, time.Second, func(ctx context.Context, d *data) {
+		<-release
+		d.response <- llmReply{text: "ok"}
+	})
+	q.Start()
+	defer q.Stop()
+
+	first := newTestData(1)
+	q.Submit(first)
+	q.Submit(newTestData(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline while requests run, got %v", err)
+	}
+
+	if err := q.Submit(newTestData(2)); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected ErrShuttingDown after drain, got %v", err)
+	}
+
+	close(release)
+	if err := q.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if reply := <-first.response; reply.text != "ok" {
+		t.Errorf("queued request must finish while draining, got %+v", reply)
+	}
+}
+
+func TestLLMQueueStopCancelsWithCause(t *testing.T) {
+	started := make(chan struct{})
+	q := newLLMQueue(1, 10, time.Minute, func(ctx context.Context, d *data) {
+		close(started)
+		<-ctx.Done()
+		d.response <- llmReply{err: context.Cause(ctx)}
+	})
+	q.Start()
+
+	running := newTestData(1)
+	queued := newTestData(1)
+	q.Submit(running)
+	q.Submit(queued)
+	<-started
+
+	q.Stop()
+
+	for _, d := range []*data{running, queued} {
+		if reply := <-d.response; !errors.Is(reply.err, ErrShuttingDown) {
+			t.Errorf("expected ErrShuttingDown, got %v", reply.err)
+		}
+	}
+	if err := q.Drain(context.Background()); err != nil {
+		t.Errorf("stopped queue must be drained, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const defaultRateLimitWindow = time.Minute
+
+// tokenBucket holds up to burst tokens and refills them evenly over the window
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (tb *tokenBucket) refill(now time.Time, burst int, window time.Duration) {
+	rate := float64(burst) / window.Seconds()
+	tb.tokens = min(float64(burst), tb.tokens+now.Sub(tb.last).Seconds()*rate)
+	tb.last = now
+}
+
+// wait returns the time until one token is available
+func (tb *tokenBucket) wait(burst int, window time.Duration) time.Duration {
+	if tb.tokens >= 1 {
+		return 0
+	}
+	rate := float64(burst) / window.Seconds()
+	return time.Duration((1 - tb.tokens) / rate * float64(time.Second))
+}
+
+type noticeKey struct {
+	chatID int64
+	userID int64
+}
+
+// rateLimiter limits LLM requests of every user and of every chat,
+// a request passes when both buckets have a token
+type rateLimiter struct {
+	mu        sync.Mutex
+	userLimit int
+	chatLimit int
+	window    time.Duration
+	users     map[int64]*tokenBucket
+	chats     map[int64]*tokenBucket
+	notices   map[noticeKey]time.Time
+	pruned    time.Time
+}
+
+// newRateLimiter creates a limiter of userLimit and chatLimit requests per window, zero limit disables the check
+func newRateLimiter(userLimit, chatLimit int, window time.Duration) *rateLimiter {
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+
+	return &rateLimiter{
+		userLimit: userLimit,
+		chatLimit: chatLimit,
+		window:    window,
+		users:     make(map[int64]*tokenBucket),
+		chats:     make(map[int64]*tokenBucket),
+		notices:   make(map[noticeKey]time.Time),
+	}
+}
+
+// Enabled reports whether any limit is set
+func (l *rateLimiter) Enabled() bool {
+	return l != nil && (l.userLimit > 0 || l.chatLimit > 0)
+}
+
+func (l *rateLimiter) bucket(buckets map[int64]*tokenBucket, key int64, limit int, now time.Time) *tokenBucket {
+	tb, ok := buckets[key]
+	if !ok {
+		tb = &tokenBucket{tokens: float64(limit), last: now}
+		buckets[key] = tb
+	}
+	tb.refill(now, limit, l.window)
+	return tb
+}
+
+// Allow takes a token of the user and of the chat. When a bucket is empty nothing is taken
+// and the time until the next request is allowed is returned
+func (l *rateLimiter) Allow(chatID, userID int64, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	var buckets []*tokenBucket
+	var wait time.Duration
+
+	if l.userLimit > 0 {
+		tb := l.bucket(l.users, userID, l.userLimit, now)
+		wait = max(wait, tb.wait(l.userLimit, l.window))
+		buckets = append(buckets, tb)
+	}
+	if l.chatLimit > 0 {
+		tb := l.bucket(l.chats, chatID, l.chatLimit, now)
+		wait = max(wait, tb.wait(l.chatLimit, l.window))
+		buckets = append(buckets, tb)
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, tb := range buckets {
+		tb.tokens--
+	}
+	return true, 0
+}
+
+// Notice reports whether the user should be told about the limit, once per window
+func (l *rateLimiter) Notice(chatID, userID int64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := noticeKey{chatID: chatID, userID: userID}
+	if last, ok := l.notices[key]; ok && now.Sub(last) < l.window {
+		return false
+	}
+
+	l.notices[key] = now
+	return true
+}
+
+// prune drops state untouched for a window, such buckets are full again
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.window {
+		return
+	}
+	l.pruned = now
+
+	for key, tb := range l.users {
+		if now.Sub(tb.last) >= l.window {
+			delete(l.users, key)
+		}
+	}
+	for key, tb := range l.chats {
+		if now.Sub(tb.last) >= l.window {
+			delete(l.chats, key)
+		}
+	}
+	for key, last := range l.notices {
+		if now.Sub(last) >= l.window {
+			delete(l.notices, key)
+		}
+	}
+}
+
+// checkRateLimit returns false when the sender exceeded the limit. Bot owners and group admins are not limited,
+// in a private chat the user is an admin but still limited
+func (b *bot) checkRateLimit(c telebot.Context) bool {
+	if !b.limiter.Enabled() || c.Sender() == nil {
+		return true
+	}
+
+	if role := b.roleOf(c); role == RoleOwner || role == RoleAdmin && c.Chat().Type != telebot.ChatPrivate {
+		return true
+	}
+
+	now := time.Now()
+	ok, wait := b.limiter.Allow(c.Chat().ID, c.Sender().ID, now)
+	if ok {
+		return true
+	}
+
+	metricErrors.WithLabelValues("rate_limited").Inc()
+	logTelegram.Warn("Rate limit exceeded", "chat", c.Chat().ID, "user", c.Sender().ID, "retry", wait.Round(time.Second).String())
+
+	if b.limiter.Notice(c.Chat().ID, c.Sender().ID, now) {
+		if err := b.send(b.rateLimitMessage(wait), c); err != nil {
+			logTelegram.Error("Error sending rate limit notice", "error", err)
+		}
+	}
+
+	return false
+}
+
+func (b *bot) rateLimitMessage(wait time.Duration) string {
+	wait = max(wait.Round(time.Second), time.Second)
+	if b.cfg().RateLimitMessage != "" {
+		return strings.ReplaceAll(b.cfg().RateLimitMessage, "{wait}", wait.String())
+	}
+	return fmt.Sprintf("You are sending requests too often, please wait %s.", wait)
+}
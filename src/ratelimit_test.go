@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUser(t *testing.T) {
+	l := newRateLimiter(2, 0, time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow(1, 10, now); !ok {
+			t.Fatalf("request %d should pass", i)
+		}
+	}
+
+	ok, wait := l.Allow(1, 10, now)
+	if ok || wait != 30*time.Second {
+		t.Errorf("expected 30s wait, got %v %s", ok, wait)
+	}
+
+	// Another user is not affected
+	if ok, _ := l.Allow(1, 20, now); !ok {
+		t.Errorf("other user should pass")
+	}
+
+	// One token is back after half of the window
+	if ok, _ := l.Allow(1, 10, now.Add(30*time.Second)); !ok {
+		t.Errorf("request should pass after refill")
+	}
+}
+
+func TestRateLimiterChat(t *testing.T) {
+	l := newRateLimiter(5, 2, time.Minute)
+	now := time.Now()
+
+	l.Allow(1, 10, now)
+	l.Allow(1, 20, now)
+
+	if ok, _ := l.Allow(1, 30, now); ok {
+		t.Errorf("chat limit should stop a new user")
+	}
+	if ok, _ := l.Allow(2, 30, now); !ok {
+		t.Errorf("other chat should pass")
+	}
+
+	// The denied request didn't take a user token, 4 of 5 are left
+	for chatID := int64(3); chatID < 7; chatID++ {
+		if ok, _ := l.Allow(chatID, 30, now); !ok {
+			t.Fatalf("request of user 30 in chat %d should pass", chatID)
+		}
+	}
+	if ok, _ := l.Allow(7, 30, now); ok {
+		t.Errorf("user limit should stop the sixth request")
+	}
+}
+
+func TestRateLimiterNotice(t *testing.T) {
+	l := newRateLimiter(1, 0, time.Minute)
+	now := time.Now()
+
+	if !l.Notice(1, 10, now) {
+		t.Errorf("first notice should be sent")
+	}
+	if l.Notice(1, 10, now.Add(30*time.Second)) {
+		t.Errorf("notice should be sent once per window")
+	}
+	if !l.Notice(1, 10, now.Add(time.Minute)) {
+		t.Errorf("notice should be sent in the next window")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	var l *rateLimiter
+	if l.Enabled() || newRateLimiter(0, 0, 0).Enabled() {
+		t.Errorf("limiter without limits should be disabled")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+)
+
+// reloadableFields are config fields that are read on every use, so they can change without a restart
+var reloadableFields = []string{
+	"Model",
+	"SystemPrompt",
+	"Temperature",
+	"NumCtx",
+	"ContextReserve",
+	"GreetingMessage",
+	"GoodbyeMessage",
+	"BacklogPolicy",
+	"TriggerWords",
+	"RemoveFromReplay",
+	"AllowedChats",
+	"AllowedUsers",
+	"Owners",
+	"SummaryBatchSize",
+	"SummaryModel",
+	"MemoryTopK",
+	"StreamEditInterval",
+	"MaxToolIterations",
+	"MaxImageBytes",
+	"MaxImageSize",
+	"QueueFullMessage",
+	"ShutdownMessage",
+	"RateLimitMessage",
+}
+
+// cfg returns the current config, a reload replaces it as a whole
+func (b *bot) cfg() *Config {
+	if config := b.liveConfig.Load(); config != nil {
+		return config
+	}
+	return b.config
+}
+
+// mergeReload copies reloadable fields of the loaded config over the current one.
+// Names of other fields that changed are returned, they need a restart
+func mergeReload(current, loaded *Config) (*Config, []string) {
+	merged := *current
+
+	mv := reflect.ValueOf(&merged).Elem()
+	lv := reflect.ValueOf(loaded).Elem()
+
+	var skipped []string
+	for i := 0; i < mv.NumField(); i++ {
+		if reflect.DeepEqual(mv.Field(i).Interface(), lv.Field(i).Interface()) {
+			continue
+		}
+
+		name := mv.Type().Field(i).Name
+		if slices.Contains(reloadableFields, name) {
+			mv.Field(i).Set(lv.Field(i))
+		} else {
+			skipped = append(skipped, name)
+		}
+	}
+
+	return &merged, skipped
+}
+
+// reloadConfig reads the config file again, an invalid file leaves the current config in place
+func (b *bot) reloadConfig(filename string) error {
+	loaded, err := loadConfig(filename)
+	if err != nil {
+		return err
+	}
+
+	merged, skipped := mergeReload(b.cfg(), loaded)
+	b.liveConfig.Store(merged)
+
+	logMain.Info("Config reloaded", "file", filename)
+	if len(skipped) > 0 {
+		logMain.Warn("Config changes need a restart", "fields", skipped)
+	}
+	return nil
+}
+
+// watchReload reloads the config on SIGHUP
+func (b *bot) watchReload(filename string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := b.reloadConfig(filename); err != nil {
+				logMain.Error("Error reloading config", "file", filename, "error", err)
+			}
+		}
+	}()
+}
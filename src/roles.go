@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const roleCacheTTL = 5 * time.Minute
+
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleAdmin
+	RoleOwner
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleOwner:
+		return "owner"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "user"
+	}
+}
+
+// roleError is returned when the sender role is lower than required
+type roleError struct {
+	required Role
+}
+
+func (e *roleError) Error() string {
+	return fmt.Sprintf("%s role required", e.required)
+}
+
+// message is the refusal shown to the user
+func (e *roleError) message() string {
+	if e.required == RoleOwner {
+		return "Only bot owners can do that."
+	}
+	return "Only chat admins can do that."
+}
+
+type roleCacheKey struct {
+	chatID int64
+	userID int64
+}
+
+type roleCacheEntry struct {
+	role    Role
+	expires time.Time
+}
+
+// roleCache keeps telegram chat admin lookups for a while, ChatMemberOf is an api call
+type roleCache struct {
+	mu      sync.Mutex
+	entries map[roleCacheKey]roleCacheEntry
+}
+
+func (rc *roleCache) get(key roleCacheKey) (Role, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return RoleUser, false
+	}
+	return entry.role, true
+}
+
+func (rc *roleCache) set(key roleCacheKey, role Role) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.entries == nil {
+		rc.entries = make(map[roleCacheKey]roleCacheEntry)
+	}
+	rc.entries[key] = roleCacheEntry{role: role, expires: time.Now().Add(roleCacheTTL)}
+}
+
+// roleOf returns the sender role: bot owners from config, chat administrators, everyone else is a user.
+// In a private chat the user administers their own chat
+func (b *bot) roleOf(c telebot.Context) Role {
+	sender := c.Sender()
+	if sender == nil {
+		return RoleUser
+	}
+
+	if slices.Contains(b.cfg().Owners, sender.ID) {
+		return RoleOwner
+	}
+
+	if c.Chat().Type == telebot.ChatPrivate {
+		return RoleAdmin
+	}
+
+	key := roleCacheKey{chatID: c.Chat().ID, userID: sender.ID}
+	if role, ok := b.roles.get(key); ok {
+		return role
+	}
+
+	member, err := c.Bot().ChatMemberOf(c.Chat(), sender)
+	if err != nil {
+		// Don't cache, the next attempt may succeed
+		logTelegram.Error("Error getting chat member", "error", err)
+		return RoleUser
+	}
+
+	role := RoleUser
+	if member.Role == telebot.Creator || member.Role == telebot.Administrator {
+		role = RoleAdmin
+	}
+	b.roles.set(key, role)
+
+	return role
+}
+
+// requireRole returns roleError when the sender role is lower than required
+func (b *bot) requireRole(c telebot.Context, required Role) error {
+	// Everyone is a user, the member lookup is not needed
+	if required == RoleUser {
+		return nil
+	}
+	if role := b.roleOf(c); role < required {
+		logCommands.Warn("Access denied", "required", required.String(), "chat", c.Chat().ID, "user", c.Sender().ID, "role", role.String())
+		return &roleError{required: required}
+	}
+	return nil
+}
+
+type roleContextKey struct{}
+
+// withRole stores the role of the user who started a request, tools use it for permission checks
+func withRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+func roleFromContext(ctx context.Context) Role {
+	role, _ := ctx.Value(roleContextKey{}).(Role)
+	return role
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/telebot.v3"
+)
+
+func newTestContext(chat *telebot.Chat, userID int64) telebot.Context {
+	return (&telebot.Bot{}).NewContext(telebot.Update{Message: &telebot.Message{
+		Sender: &telebot.User{ID: userID},
+		Chat:   chat,
+	}})
+}
+
+func TestRoleOf(t *testing.T) {
+	b := &bot{config: &Config{Owners: []int64{1}}}
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+
+	if role := b.roleOf(newTestContext(group, 1)); role != RoleOwner {
+		t.Errorf("expected owner, got %s", role)
+	}
+
+	if role := b.roleOf(newTestContext(&telebot.Chat{ID: 2, Type: telebot.ChatPrivate}, 2)); role != RoleAdmin {
+		t.Errorf("expected admin in private chat, got %s", role)
+	}
+
+	// Cached lookups don't hit the telegram api
+	b.roles.set(roleCacheKey{chatID: -100, userID: 3}, RoleAdmin)
+	if role := b.roleOf(newTestContext(group, 3)); role != RoleAdmin {
+		t.Errorf("expected cached admin, got %s", role)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	b := &bot{config: &Config{}}
+	group := &telebot.Chat{ID: -100, Type: telebot.ChatSuperGroup}
+	b.roles.set(roleCacheKey{chatID: -100, userID: 3}, RoleUser)
+
+	c := newTestContext(group, 3)
+
+	if err := b.requireRole(c, RoleUser); err != nil {
+		t.Errorf("expected users to pass, got %v", err)
+	}
+
+	// Unknown members are not looked up for user commands
+	if err := b.requireRole(newTestContext(group, 4), RoleUser); err != nil {
+		t.Errorf("expected users to pass, got %v", err)
+	}
+	if _, ok := b.roles.get(roleCacheKey{chatID: -100, userID: 4}); ok {
+		t.Errorf("user commands must not look up the member")
+	}
+
+	var roleErr *roleError
+	if err := b.requireRole(c, RoleAdmin); !errors.As(err, &roleErr) || roleErr.required != RoleAdmin {
+		t.Errorf("expected role error, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	settingModel  = "model"
+	settingSystem = "system"
+)
+
+// ChatSettings overrides config values for one chat, empty values fall back to the config.
+// Options are keyed by their ollama names (temperature, top_k, ...), nil means not set
+type ChatSettings struct {
+	mu           sync.Mutex     `json:"-"`
+	Model        string         `json:"model,omitempty"`
+	SystemPrompt string         `json:"systemPrompt,omitempty"`
+	Options      ollama.Options `json:"options"`
+	onChange     func()         `json:"-"`
+}
+
+func (s *ChatSettings) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(struct {
+		Model        string         `json:"model,omitempty"`
+		SystemPrompt string         `json:"systemPrompt,omitempty"`
+		Options      ollama.Options `json:"options"`
+	}{s.Model, s.SystemPrompt, s.Options})
+}
+
+// SettingNames returns every key accepted by Set
+func SettingNames() []string {
+	names := []string{settingModel, settingSystem}
+
+	t := reflect.TypeOf(ollama.Options{})
+	optionNames := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		optionNames = append(optionNames, optionName(t.Field(i)))
+	}
+	sort.Strings(optionNames)
+
+	return append(names, optionNames...)
+}
+
+// Set parses and stores a setting, an empty value resets it
+func (s *ChatSettings) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.set(key, value); err != nil {
+		return err
+	}
+	notify(s.onChange)
+	return nil
+}
+
+func (s *ChatSettings) set(key, value string) error {
+	switch key {
+	case settingModel:
+		s.Model = value
+		return nil
+	case settingSystem:
+		s.SystemPrompt = value
+		return nil
+	}
+
+	field, ok := optionField(&s.Options, key)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+
+	if value == "" {
+		field.SetZero()
+		return nil
+	}
+
+	// Numbers are pointers, so zero is a value too
+	target := field
+	if field.Kind() == reflect.Pointer {
+		target = reflect.New(field.Type().Elem()).Elem()
+	}
+
+	switch target.Kind() {
+	case reflect.Int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", key)
+		}
+		target.SetInt(int64(v))
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", key)
+		}
+		target.SetFloat(v)
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Slice:
+		// stop takes several sequences separated by commas
+		var list []string
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("%s must not be empty", key)
+		}
+		target.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting %q", key)
+	}
+
+	if field.Kind() == reflect.Pointer {
+		field.Set(target.Addr())
+	}
+	return nil
+}
+
+// List returns the settings that are set for the chat
+func (s *ChatSettings) List() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make(map[string]string)
+	if s.Model != "" {
+		list[settingModel] = s.Model
+	}
+	if s.SystemPrompt != "" {
+		list[settingSystem] = s.SystemPrompt
+	}
+
+	v := reflect.ValueOf(s.Options)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.IsZero() {
+			continue
+		}
+		if field.Kind() == reflect.Pointer {
+			field = field.Elem()
+		}
+		if stop, ok := field.Interface().([]string); ok {
+			list[optionName(v.Type().Field(i))] = strings.Join(stop, ",")
+			continue
+		}
+		list[optionName(v.Type().Field(i))] = fmt.Sprint(field.Interface())
+	}
+
+	return list
+}
+
+func (s *ChatSettings) get() (model, systemPrompt string, options ollama.Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Model, s.SystemPrompt, s.Options
+}
+
+// chatModel returns the model used in the chat
+func (b *bot) chatModel(chat *ChatContext) string {
+	if model, _, _ := chat.Settings.get(); model != "" {
+		return model
+	}
+	return b.providerModel(b.chatProviderName(chat))
+}
+
+// providerModel returns the model used by the provider when the chat has no model set
+func (b *bot) providerModel(name string) string {
+	if pc, ok := b.cfg().Providers[name]; ok && pc.Model != "" {
+		return pc.Model
+	}
+	return b.cfg().Model
+}
+
+// chatSystemPrompt returns the system prompt used in the chat
+func (b *bot) chatSystemPrompt(chat *ChatContext) string {
+	if _, prompt, _ := chat.Settings.get(); prompt != "" {
+		return prompt
+	}
+	return b.cfg().SystemPrompt
+}
+
+// chatOptions merges chat options over the config defaults
+func (b *bot) chatOptions(chat *ChatContext) *ollama.Options {
+	// Zero config values are not set, the model defaults are used
+	options := &ollama.Options{}
+	if b.cfg().Temperature != 0 {
+		options.Temperature = ollama.Ptr(b.cfg().Temperature)
+	}
+	if b.cfg().NumCtx != 0 {
+		options.NumCtx = ollama.Ptr(b.cfg().NumCtx)
+	}
+
+	_, _, overrides := chat.Settings.get()
+
+	dst := reflect.ValueOf(options).Elem()
+	src := reflect.ValueOf(overrides)
+	for i := 0; i < src.NumField(); i++ {
+		// Set pointers are copied even when they point to zero
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+
+	return options
+}
+
+func optionName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+func optionField(options *ollama.Options, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(options).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if optionName(v.Type().Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChatSettingsSet(t *testing.T) {
+	s := &ChatSettings{}
+
+	if err := s.Set("temperature", "0,7"); err != nil {
+		t.Fatalf("Set temperature: %v", err)
+	}
+	if err := s.Set("top_k", "40"); err != nil {
+		t.Fatalf("Set top_k: %v", err)
+	}
+	if err := s.Set("stop", "User:, Assistant:"); err != nil {
+		t.Fatalf("Set stop: %v", err)
+	}
+	if err := s.Set("top_k", "many"); err == nil {
+		t.Errorf("expected error for invalid integer")
+	}
+	if err := s.Set("unknown", "1"); err == nil {
+		t.Errorf("expected error for unknown setting")
+	}
+
+	if *s.Options.Temperature != 0.7 || *s.Options.TopK != 40 || !slices.Equal(s.Options.Stop, []string{"User:", "Assistant:"}) {
+		t.Errorf("unexpected options %+v", s.Options)
+	}
+
+	if err := s.Set("top_k", ""); err != nil || s.Options.TopK != nil {
+		t.Errorf("expected top_k to be reset, got %v, %v", s.Options.TopK, err)
+	}
+
+	list := s.List()
+	if len(list) != 2 || list["temperature"] != "0.7" || list["stop"] != "User:,Assistant:" {
+		t.Errorf("unexpected list %v", list)
+	}
+}
+
+func TestChatOptionsMerge(t *testing.T) {
+	b := &bot{config: &Config{Model: "llama", SystemPrompt: "be nice", Temperature: 0.9, NumCtx: 2048}}
+	chat := NewChatContext(1, 10)
+
+	if b.chatModel(chat) != "llama" || b.chatSystemPrompt(chat) != "be nice" {
+		t.Errorf("expected config defaults")
+	}
+
+	chat.Settings.Set("model", "mistral")
+	chat.Settings.Set("system", "be rude")
+	chat.Settings.Set("temperature", "0.2")
+	chat.Settings.Set("seed", "42")
+
+	options := b.chatOptions(chat)
+	if *options.Temperature != 0.2 || *options.NumCtx != 2048 || *options.Seed != 42 {
+		t.Errorf("unexpected options %+v", options)
+	}
+	if b.chatModel(chat) != "mistral" || b.chatSystemPrompt(chat) != "be rude" {
+		t.Errorf("expected chat overrides")
+	}
+}
+
+func TestChatOptionsZero(t *testing.T) {
+	b := &bot{config: &Config{Model: "llama", Temperature: 0.9}}
+	chat := NewChatContext(1, 10)
+
+	// /set temperature 0
+	if rs, err := b.cmdSet(nil, chat, "temperature 0"); err != nil || rs != "temperature = 0" {
+		t.Fatalf("cmdSet = %q, %v", rs, err)
+	}
+	chat.Settings.Set("seed", "0")
+
+	options := b.chatOptions(chat)
+	if options.Temperature == nil || *options.Temperature != 0 || options.Seed == nil || *options.Seed != 0 {
+		t.Errorf("zero overrides must be used, got %+v", options)
+	}
+	if list := chat.Settings.List(); list["temperature"] != "0" {
+		t.Errorf("zero setting must be listed, got %v", list)
+	}
+
+	data, err := json.Marshal(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"temperature":0`) || !strings.Contains(string(data), `"seed":0`) {
+		t.Errorf("zero options must be sent, got %s", data)
+	}
+}
+
+func TestSettingNames(t *testing.T) {
+	names := SettingNames()
+	for _, name := range []string{"model", "system", "temperature", "top_p", "top_k", "min_p", "repeat_penalty", "seed", "stop", "num_predict", "mirostat"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("setting %q is missing", name)
+		}
+	}
+}
+
+func TestChatSettingsPersisted(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contexts := NewChatContexts(10, store)
+	contexts.Get(1).Settings.Set("top_p", "0.5")
+	if err := contexts.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := NewChatContexts(10, store).Get(1)
+	if loaded.Settings.Options.TopP == nil || *loaded.Settings.Options.TopP != 0.5 {
+		t.Errorf("settings not loaded: %+v", loaded.Settings.Options)
+	}
+}
+
+func TestChatSettingsSetNotifiesStoredValues(t *testing.T) {
+	changes := 0
+	s := &ChatSettings{onChange: func() { changes++ }}
+
+	if s.Set("unknown", "1") == nil || s.Set("top_k", "many") == nil {
+		t.Fatal("expected errors")
+	}
+	if changes != 0 {
+		t.Errorf("failed settings must not be saved, got %d changes", changes)
+	}
+
+	if err := s.Set("top_k", "40"); err != nil || changes != 1 {
+		t.Errorf("expected one change, got %d %v", changes, err)
+	}
+}
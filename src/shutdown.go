@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// shutdownGrace bounds the steps after the queue is stopped: sending the last replies and closing the HTTP server
+const shutdownGrace = 10 * time.Second
+
+// beginHandler registers a running handler, false is returned when the bot is shutting down
+func (b *bot) beginHandler() bool {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+
+	if b.shuttingDown.Load() {
+		return false
+	}
+	b.inflight.Add(1)
+	return true
+}
+
+// dispatchUpdate handles the update in its own goroutine, the bot is synchronous so the handler has finished
+// when ProcessUpdate returns. Updates that come during shutdown are not handled and not saved in the offset
+func (b *bot) dispatchUpdate(upd telebot.Update) {
+	b.updates.start(upd.ID)
+
+	go func() {
+		if !b.beginHandler() {
+			return
+		}
+		defer b.inflight.Done()
+
+		b.tgBot.ProcessUpdate(upd)
+		b.updates.done(upd.ID)
+	}()
+}
+
+// stopAccepting makes new handlers return right away and the bot not ready
+func (b *bot) stopAccepting() {
+	b.inflightMu.Lock()
+	defer b.inflightMu.Unlock()
+
+	b.shuttingDown.Store(true)
+}
+
+// waitHandlers waits until running handlers have sent their replies or ctx ends
+func (b *bot) waitHandlers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops taking updates and lets queued requests finish until the shutdown timeout,
+// requests still running after it are cancelled and their users get the shutdown message
+func (b *bot) shutdown() {
+	b.tgBot.Stop()
+	b.stopAccepting()
+
+	// Telegram keeps updates until a webhook or poller takes them again
+	if b.cfg().WebhookURL != "" {
+		if err := b.tgBot.RemoveWebhook(); err != nil {
+			logTelegram.Error("Error removing webhook", "error", err)
+		}
+	}
+
+	timeout := time.Duration(b.cfg().ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := b.queue.Drain(ctx); err != nil {
+		logLLM.Warn("Shutdown timeout reached, cancelling requests", "pending", b.queue.Len(), "timeout", timeout)
+	}
+	b.queue.Stop()
+
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancelGrace()
+
+	if err := b.waitHandlers(graceCtx); err != nil {
+		logTelegram.Warn("Handlers did not finish in time", "error", err)
+	}
+}
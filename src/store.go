@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned by Store.Load when nothing was saved under the key
+var ErrNotFound = errors.New("not found")
+
+// Store keeps serialized chat data by key
+type Store interface {
+	Load(key string) ([]byte, error)
+	Save(key string, data []byte) error
+	Close() error
+}
+
+// newStore creates the store selected in the config
+func newStore(config *Config) (Store, error) {
+	dir := config.HistoryDir
+	if dir == "" {
+		dir = "."
+	}
+
+	switch config.Storage {
+	case "", "file":
+		return NewFileStore(dir)
+	case "bolt":
+		path := config.StoragePath
+		if path == "" {
+			path = filepath.Join(dir, "history.db")
+		}
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", config.Storage)
+	}
+}
+
+// FileStore keeps every key in its own json file of a directory
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *FileStore) Load(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Save writes data to a temporary file and renames it over the old one,
+// so a crash leaves either the old or the new file
+func (s *FileStore) Save(key string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+var boltBucket = []byte("chats")
+
+// BoltStore keeps keys in an embedded bbolt database file
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		// The value is only valid during the transaction
+		data = bytes.Clone(tx.Bucket(boltBucket).Get([]byte(key)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *BoltStore) Save(key string, data []byte) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
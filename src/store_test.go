@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	if _, err := store.Load("1_history"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		if err := store.Save("1_history", []byte(data)); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+
+	data, err := store.Load("1_history")
+	if err != nil || string(data) != "second" {
+		t.Errorf("unexpected data %q, %v", data, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+
+	// Temporary files are removed after rename
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, store)
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if data, err := store.Load("1_history"); err != nil || string(data) != "second" {
+		t.Errorf("unexpected data after reopen %q, %v", data, err)
+	}
+}
+
+// countingStore counts saves of the wrapped store
+type countingStore struct {
+	Store
+	saves int
+}
+
+func (s *countingStore) Save(key string, data []byte) error {
+	s.saves++
+	return s.Store.Save(key, data)
+}
+
+func TestChatContextsSaveChanged(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingStore{Store: fileStore}
+
+	contexts := NewChatContexts(10, store)
+	contexts.Get(1).History.Add(Message{UserType: UserTypeUser, Message: "hello"})
+	contexts.Get(2)
+	contexts.Get(3).Vectors.Add("embed", VectorEntry{Source: vectorSourceMemory, Text: "fact", Vector: []float32{1}})
+
+	if err := contexts.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if store.saves != 2 {
+		t.Errorf("expected only changed chats to be saved, got %d saves", store.saves)
+	}
+
+	if err := contexts.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if store.saves != 2 {
+		t.Errorf("expected no saves without changes, got %d", store.saves)
+	}
+
+	loaded := NewChatContexts(10, store).Get(3)
+	if loaded.Vectors.Len() != 1 || loaded.Vectors.Model != "embed" {
+		t.Errorf("vector index not loaded: %+v", loaded.Vectors.Entries)
+	}
+}
+
+func TestChatContextsRun(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contexts := NewChatContexts(10, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		contexts.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	contexts.Get(1).Memory.Add("fact")
+	cancel()
+	<-done
+
+	if _, err := store.Load(historyKey(1)); err != nil {
+		t.Errorf("expected the chat to be saved on stop: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	defaultStreamEditInterval = 1500 * time.Millisecond
+	maxMessageLength          = 4000
+)
+
+// streamReply shows a generating answer by editing one telegram message
+type streamReply struct {
+	c        telebot.Context
+	interval time.Duration
+
+	msg   *telebot.Message
+	shown string
+	next  time.Time
+	plain bool
+}
+
+func (b *bot) newStreamReply(c telebot.Context) *streamReply {
+	interval := time.Duration(b.cfg().StreamEditInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultStreamEditInterval
+	}
+
+	return &streamReply{
+		c:        c,
+		interval: interval,
+	}
+}
+
+// Update shows partial text, calls are dropped until the edit interval has passed
+func (s *streamReply) Update(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == s.shown || time.Now().Before(s.next) {
+		return
+	}
+
+	err := s.show(text)
+	s.next = time.Now().Add(s.interval)
+
+	var floodErr telebot.FloodError
+	switch {
+	case err == nil:
+		s.shown = text
+	case errors.As(err, &floodErr):
+		s.next = time.Now().Add(time.Duration(floodErr.RetryAfter) * time.Second)
+	default:
+		logTelegram.Warn("Error editing stream message", "error", err)
+		metricTelegramFailures.WithLabelValues("edit").Inc()
+	}
+}
+
+// Finish replaces the partial text with the final replay
+func (s *streamReply) Finish(b *bot, replay any) error {
+	text, ok := replay.(string)
+	if !ok || s.msg == nil {
+		s.Abort()
+		return b.send(replay, s.c)
+	}
+
+	mode := telebot.ModeMarkdownV2
+	parts := splitMessage(escapeMarkdownV2(text), maxMessageLength)
+	if len(parts) == 0 {
+		s.Abort()
+		return nil
+	}
+
+	_, err := s.c.Bot().Edit(s.msg, parts[0], mode)
+	if isParseError(err) {
+		mode = telebot.ModeDefault
+		parts = splitMessage(text, maxMessageLength)
+		_, err = s.c.Bot().Edit(s.msg, parts[0], mode)
+	}
+	if err != nil && !isNotModifiedError(err) {
+		logTelegram.Warn("Error finishing stream message", "error", err)
+		metricTelegramFailures.WithLabelValues("edit").Inc()
+		return err
+	}
+
+	for _, part := range parts[1:] {
+		if err := s.c.Send(part, &telebot.SendOptions{ParseMode: mode, ReplyTo: s.c.Message()}); err != nil {
+			logTelegram.Error("Error sending reply", "error", err)
+			metricTelegramFailures.WithLabelValues("send").Inc()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Abort removes the partial message if it was already sent
+func (s *streamReply) Abort() {
+	if s.msg == nil {
+		return
+	}
+
+	if err := s.c.Bot().Delete(s.msg); err != nil {
+		logTelegram.Warn("Error deleting stream message", "error", err)
+		metricTelegramFailures.WithLabelValues("delete").Inc()
+	}
+	s.msg = nil
+}
+
+func (s *streamReply) show(text string) error {
+	// Cut after escaping, escape characters count against the telegram limit too
+	if !s.plain {
+		err := s.showWithMode(truncateMessage(escapeMarkdownV2(text), maxMessageLength), telebot.ModeMarkdownV2)
+		if !isParseError(err) {
+			return err
+		}
+		// Partial text can cut markdown in the middle, keep going without formatting
+		s.plain = true
+	}
+
+	return s.showWithMode(truncateMessage(text, maxMessageLength), telebot.ModeDefault)
+}
+
+func (s *streamReply) showWithMode(text string, mode telebot.ParseMode) error {
+	if s.msg == nil {
+		msg, err := s.c.Bot().Send(s.c.Recipient(), text, &telebot.SendOptions{
+			ParseMode: mode,
+			ReplyTo:   s.c.Message(),
+		})
+		if err != nil {
+			return err
+		}
+		s.msg = msg
+		return nil
+	}
+
+	_, err := s.c.Bot().Edit(s.msg, text, mode)
+	if isNotModifiedError(err) {
+		return nil
+	}
+	return err
+}
+
+// truncateMessage cuts text to size runes ending with an ellipsis,
+// an escape backslash is not left without the character it escapes
+func truncateMessage(text string, size int) string {
+	if utf8.RuneCountInString(text) <= size {
+		return text
+	}
+
+	runes := []rune(text)[:size-1]
+	backslashes := 0
+	for i := len(runes) - 1; i >= 0 && runes[i] == '\\'; i-- {
+		backslashes++
+	}
+	if backslashes%2 == 1 {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes) + "…"
+}
+
+func splitMessage(text string, size int) []string {
+	if text == "" {
+		return nil
+	}
+
+	runes := []rune(text)
+	parts := make([]string, 0, len(runes)/size+1)
+	for i := 0; i < len(runes); i += size {
+		end := min(i+size, len(runes))
+		parts = append(parts, string(runes[i:end]))
+	}
+
+	return parts
+}
+
+func isParseError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
+
+func isNotModifiedError(err error) bool {
+	return err != nil && (errors.Is(err, telebot.ErrMessageNotModified) || strings.Contains(err.Error(), "message is not modified"))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telebot.v3"
+)
+
+func TestSplitMessage(t *testing.T) {
+	testCases := []struct {
+		input    string
+		size     int
+		expected []string
+	}{
+		{input: "", size: 3, expected: nil},
+		{input: "abc", size: 3, expected: []string{"abc"}},
+		{input: "abcdefg", size: 3, expected: []string{"abc", "def", "g"}},
+		{input: "привет", size: 4, expected: []string{"прив", "ет"}},
+	}
+
+	for _, tc := range testCases {
+		actual := splitMessage(tc.input, tc.size)
+		if strings.Join(actual, "|") != strings.Join(tc.expected, "|") || len(actual) != len(tc.expected) {
+			t.Errorf("splitMessage(%q, %d) = %q; expected %q", tc.input, tc.size, actual, tc.expected)
+		}
+	}
+}
+
+// telegramCall is a request to the fake bot api
+type telegramCall struct {
+	method    string
+	text      string
+	parseMode string
+}
+
+// newFakeTelegram returns a context whose bot sends to a fake api, rejectMarkdown makes it fail on MarkdownV2 texts
+func newFakeTelegram(t *testing.T, rejectMarkdown bool) (telebot.Context, *[]telegramCall) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		calls []telegramCall
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+
+		call := telegramCall{method: path.Base(r.URL.Path)}
+		call.text, _ = params["text"].(string)
+		call.parseMode, _ = params["parse_mode"].(string)
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+
+		if rejectMarkdown && call.parseMode == string(telebot.ModeMarkdownV2) {
+			w.Write([]byte(`{"ok": false, "error_code": 400, "description": "Bad Request: can't parse entities: unexpected end"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"ok": true, "result": {"message_id": 10, "chat": {"id": 1}, "date": 0, "text": %q}}`, call.text)
+	}))
+	t.Cleanup(server.Close)
+
+	tgBot, err := telebot.NewBot(telebot.Settings{URL: server.URL, Token: "t", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tgBot.NewContext(telebot.Update{Message: &telebot.Message{ID: 5, Chat: &telebot.Chat{ID: 1}}})
+	return c, &calls
+}
+
+func TestStreamReplyThrottle(t *testing.T) {
+	c, calls := newFakeTelegram(t, false)
+	s := &streamReply{c: c, interval: time.Hour}
+
+	s.Update("Hello")
+	s.Update("Hello world")
+
+	if len(*calls) != 1 || (*calls)[0].method != "sendMessage" || (*calls)[0].text != "Hello" {
+		t.Fatalf("updates within the interval must be dropped, got %+v", *calls)
+	}
+
+	s.next = time.Now()
+	s.Update("Hello world")
+	s.Update("Hello world")
+
+	if len(*calls) != 2 || (*calls)[1].method != "editMessageText" || (*calls)[1].text != "Hello world" {
+		t.Errorf("expected one edit after the interval, got %+v", *calls)
+	}
+}
+
+func TestStreamReplyPlainFallback(t *testing.T) {
+	c, calls := newFakeTelegram(t, true)
+	s := &streamReply{c: c, interval: time.Hour}
+
+	s.Update("*unclosed bold")
+
+	if !s.plain || s.shown != "*unclosed bold" {
+		t.Fatalf("expected plain text after a parse error, got plain=%v shown=%q", s.plain, s.shown)
+	}
+	if len(*calls) != 2 || (*calls)[1].parseMode != "" || (*calls)[1].text != "*unclosed bold" {
+		t.Errorf("unexpected calls %+v", *calls)
+	}
+
+	s.next = time.Now()
+	s.Update("*unclosed bold text")
+	if last := (*calls)[len(*calls)-1]; len(*calls) != 3 || last.parseMode != "" {
+		t.Errorf("later edits must stay plain, got %+v", *calls)
+	}
+}
+
+func TestStreamReplyLongText(t *testing.T) {
+	c, calls := newFakeTelegram(t, false)
+	s := &streamReply{c: c, interval: time.Hour}
+
+	// Every dot is escaped, so the escaped text is twice as long
+	s.Update(strings.Repeat(".", 3000))
+
+	if len(*calls) != 1 {
+		t.Fatalf("unexpected calls %+v", *calls)
+	}
+	if n := utf8.RuneCountInString((*calls)[0].text); n > maxMessageLength {
+		t.Errorf("sent text has %d characters, more than %d", n, maxMessageLength)
+	}
+	if text := (*calls)[0].text; strings.HasSuffix(strings.TrimSuffix(text, "…"), `\`) {
+		t.Errorf("text must not end with a lone escape character")
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	testCases := []struct {
+		input    string
+		size     int
+		expected string
+	}{
+		{input: "abc", size: 3, expected: "abc"},
+		{input: "abcdef", size: 4, expected: "abc…"},
+		{input: `ab\.cd`, size: 4, expected: "ab…"},
+		{input: `a\\bcd`, size: 4, expected: `a\\…`},
+	}
+
+	for _, tc := range testCases {
+		if actual := truncateMessage(tc.input, tc.size); actual != tc.expected {
+			t.Errorf("truncateMessage(%q, %d) = %q; expected %q", tc.input, tc.size, actual, tc.expected)
+		}
+	}
+}
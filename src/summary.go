@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	defaultSummaryBatchSize = 20
+	summaryTimeout          = 5 * time.Minute
+)
+
+const summaryPrompt = `You maintain a summary of a group chat conversation. ` +
+	`Update the existing summary with the new messages. Keep names, facts, decisions, promises and open questions, ` +
+	`drop greetings and small talk. Write in the language of the conversation, no more than 15 short sentences. ` +
+	`Reply with the updated summary only.`
+
+// Summary is a rolling summary of messages evicted from the history,
+// evicted messages wait in Pending until they are summarized in a batch
+type Summary struct {
+	mu      sync.Mutex
+	Text    string    `json:"text"`
+	Pending []Message `json:"pending,omitempty"`
+	running bool
+
+	// generation changes on Clear, a batch started before it is dropped
+	generation      int
+	batchGeneration int
+
+	onChange func()
+}
+
+func (s *Summary) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(struct {
+		Text    string    `json:"text"`
+		Pending []Message `json:"pending,omitempty"`
+	}{s.Text, s.Pending})
+}
+
+// Get returns the current summary text
+func (s *Summary) Get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Text
+}
+
+// AddPending queues an evicted message and returns the number of queued messages
+func (s *Summary) AddPending(msg Message) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Pending = append(s.Pending, msg)
+	notify(s.onChange)
+	return len(s.Pending)
+}
+
+// Clear drops the summary and queued messages
+func (s *Summary) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Text = ""
+	s.Pending = nil
+	s.generation++
+	notify(s.onChange)
+}
+
+// startBatch takes up to size queued messages, false is returned if a batch is already running
+func (s *Summary) startBatch(size int) ([]Message, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running || len(s.Pending) == 0 {
+		return nil, "", false
+	}
+	s.running = true
+	s.batchGeneration = s.generation
+
+	n := min(size, len(s.Pending))
+	batch := make([]Message, n)
+	copy(batch, s.Pending[:n])
+
+	return batch, s.Text, true
+}
+
+// finishBatch stores the new summary and removes summarized messages from the queue,
+// on failure they stay queued for the next attempt. The result is dropped when Clear ran meanwhile
+func (s *Summary) finishBatch(batch []Message, text string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running = false
+	if !ok || s.generation != s.batchGeneration {
+		return
+	}
+
+	s.Text = text
+	s.Pending = s.Pending[len(batch):]
+	notify(s.onChange)
+}
+
+// onHistoryEvict archives messages dropped from a full history and starts a summary when a batch is collected
+func (b *bot) onHistoryEvict(chat *ChatContext, msg Message) {
+	if b.cfg().ArchiveHistory && b.cfg().EmbeddingModel != "" {
+		go b.archiveMessage(chat, msg)
+	}
+
+	if !b.cfg().EnableSummary {
+		return
+	}
+
+	batchSize := b.summaryBatchSize()
+	if chat.Summary.AddPending(msg) < batchSize {
+		return
+	}
+
+	go b.summarize(chat, batchSize)
+}
+
+func (b *bot) summaryBatchSize() int {
+	if b.cfg().SummaryBatchSize > 0 {
+		return b.cfg().SummaryBatchSize
+	}
+	return defaultSummaryBatchSize
+}
+
+func (b *bot) summarize(chat *ChatContext, batchSize int) {
+	batch, current, ok := chat.Summary.startBatch(batchSize)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+	defer cancel()
+
+	text, err := b.requestSummary(ctx, chat, current, batch)
+	if err != nil {
+		logLLM.Error("Error summarizing chat", "chat", chat.Chat, "error", err)
+		chat.Summary.finishBatch(batch, "", false)
+		return
+	}
+
+	chat.Summary.finishBatch(batch, text, true)
+	logLLM.Info("Summary updated", "chat", chat.Chat, "messages", len(batch))
+}
+
+func (b *bot) requestSummary(ctx context.Context, chat *ChatContext, current string, batch []Message) (string, error) {
+	var conversation strings.Builder
+	if current != "" {
+		fmt.Fprintf(&conversation, "Current summary:\n%s\n\n", current)
+	}
+	conversation.WriteString("New messages:\n")
+	for _, msg := range batch {
+		fmt.Fprintf(&conversation, "%s: %s\n", msg.UserType, msg.Message)
+	}
+
+	model := b.cfg().SummaryModel
+	if model == "" {
+		model = b.chatModel(chat)
+	}
+
+	resp, err := b.chatProvider(chat).Chat(ctx, &ollama.ChatRequest{
+		Model: model,
+		Messages: []ollama.Message{
+			ollama.MakeMessage(string(UserTypeSystem), summaryPrompt),
+			ollama.MakeMessage(string(UserTypeUser), conversation.String()),
+		},
+		AdvancedParams: ollama.AdvancedParams{
+			Options: &ollama.Options{
+				NumCtx:      b.chatOptions(chat).NumCtx,
+				Temperature: ollama.Ptr(0.2),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(resp.Message.Content)
+	if text == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+
+	return text, nil
+}
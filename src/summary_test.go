@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+func TestHistoryEvictQueuesSummary(t *testing.T) {
+	b := &bot{config: &Config{EnableSummary: true, SummaryBatchSize: 100}}
+
+	contexts := NewChatContexts(2, nil)
+	contexts.OnEvict(b.onHistoryEvict)
+
+	chat := contexts.Get(1)
+	for _, text := range []string{"one", "two", "three", "four"} {
+		chat.History.Add(Message{UserType: UserTypeUser, Message: text})
+	}
+
+	if len(chat.Summary.Pending) != 2 || chat.Summary.Pending[0].Message != "one" {
+		t.Errorf("unexpected pending messages %+v", chat.Summary.Pending)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollama.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		prompt = req.Messages[len(req.Messages)-1].Content
+
+		json.NewEncoder(w).Encode(ollama.ChatResponse{Message: ollama.MakeMessage("assistant", "new summary"), Done: true})
+	}))
+	defer server.Close()
+
+	client, err := ollama.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bot{config: &Config{Model: "llama"}, ollama: client}
+
+	chat := NewChatContext(1, 10)
+	chat.Summary.Text = "old summary"
+	chat.Summary.AddPending(Message{UserType: UserTypeUser, Message: "first"})
+	chat.Summary.AddPending(Message{UserType: UserTypeAI, Message: "second"})
+	chat.Summary.AddPending(Message{UserType: UserTypeUser, Message: "third"})
+
+	b.summarize(chat, 2)
+
+	if !strings.Contains(prompt, "old summary") || !strings.Contains(prompt, "second") || strings.Contains(prompt, "third") {
+		t.Errorf("unexpected prompt %q", prompt)
+	}
+	if chat.Summary.Get() != "new summary" {
+		t.Errorf("unexpected summary %q", chat.Summary.Get())
+	}
+	if len(chat.Summary.Pending) != 1 || chat.Summary.Pending[0].Message != "third" {
+		t.Errorf("unexpected pending messages %+v", chat.Summary.Pending)
+	}
+}
+
+func TestSummarizeKeepsPendingOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client, err := ollama.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bot{config: &Config{Model: "llama"}, ollama: client}
+
+	chat := NewChatContext(1, 10)
+	chat.Summary.AddPending(Message{UserType: UserTypeUser, Message: "first"})
+
+	b.summarize(chat, 2)
+
+	if len(chat.Summary.Pending) != 1 || chat.Summary.Get() != "" {
+		t.Errorf("unexpected summary state %+v", chat.Summary)
+	}
+}
+
+func TestSummaryClearDuringBatch(t *testing.T) {
+	var s Summary
+	s.AddPending(Message{UserType: UserTypeUser, Message: "first"})
+
+	batch, _, ok := s.startBatch(2)
+	if !ok {
+		t.Fatal("expected a batch")
+	}
+
+	// /reset while the model summarizes
+	s.Clear()
+	s.AddPending(Message{UserType: UserTypeUser, Message: "after reset"})
+	s.finishBatch(batch, "summary of first", true)
+
+	if s.Get() != "" || len(s.Pending) != 1 || s.Pending[0].Message != "after reset" {
+		t.Errorf("cleared history must not come back, got %q %+v", s.Get(), s.Pending)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const (
+	defaultCharsPerToken  = 3.0
+	defaultNumCtx         = 2048
+	defaultContextReserve = 512
+
+	// Rough cost of one image for vision models
+	imageTokens = 768
+
+	// Chat template adds role markers around every message
+	messageTokenOverhead = 4
+)
+
+// tokenEstimator guesses token counts from text length. The chars per token ratio is
+// calibrated per model with prompt_eval_count that ollama reports for the whole prompt
+type tokenEstimator struct {
+	mu     sync.Mutex
+	ratios map[string]float64
+}
+
+func (e *tokenEstimator) ratio(model string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if r, ok := e.ratios[model]; ok {
+		return r
+	}
+	return defaultCharsPerToken
+}
+
+// Estimate returns the approximate number of tokens of a message
+func (e *tokenEstimator) Estimate(model, text string) int {
+	return int(float64(utf8.RuneCountInString(text))/e.ratio(model)) + messageTokenOverhead
+}
+
+// Observe adjusts the ratio of the model with the real token count of a prompt
+func (e *tokenEstimator) Observe(model string, chars, messages, tokens int) {
+	tokens -= messages * messageTokenOverhead
+	if chars <= 0 || tokens <= 0 {
+		return
+	}
+
+	// Ollama reuses cached prompt prefixes and then reports fewer tokens,
+	// such samples give an unrealistic ratio and are skipped
+	sample := float64(chars) / float64(tokens)
+	if sample < 1 || sample > 8 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ratios == nil {
+		e.ratios = make(map[string]float64)
+	}
+
+	r, ok := e.ratios[model]
+	if !ok {
+		r = defaultCharsPerToken
+	}
+	e.ratios[model] = r*0.7 + sample*0.3
+}
+
+// promptChars counts characters of the request the way Observe expects them
+func promptChars(payload *ollama.ChatRequest) int {
+	chars := 0
+	for _, msg := range payload.Messages {
+		chars += utf8.RuneCountInString(msg.Content)
+	}
+
+	if len(payload.Tools) > 0 {
+		if tools, err := json.Marshal(payload.Tools); err == nil {
+			chars += utf8.RuneCount(tools)
+		}
+	}
+
+	return chars
+}
+
+// contextBudget returns how many prompt tokens fit into the context window, leaving room for the answer
+func (b *bot) contextBudget(options *ollama.Options) int {
+	numCtx := defaultNumCtx
+	if options.NumCtx != nil && *options.NumCtx > 0 {
+		numCtx = *options.NumCtx
+	}
+
+	reserve := b.cfg().ContextReserve
+	if reserve <= 0 {
+		reserve = defaultContextReserve
+	}
+	if options.NumPredict != nil && *options.NumPredict > 0 {
+		reserve = *options.NumPredict
+	}
+
+	return max(numCtx-reserve, 0)
+}
+
+// fitHistory keeps the newest messages whose estimated size fits into budget tokens
+func (b *bot) fitHistory(model string, history []Message, budget int) []Message {
+	start := len(history)
+	for start > 0 {
+		cost := b.tokens.Estimate(model, history[start-1].Message)
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start--
+	}
+
+	return history[start:]
+}
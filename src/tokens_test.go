@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTokenEstimatorObserve(t *testing.T) {
+	var e tokenEstimator
+
+	if got := e.Estimate("llama", strings.Repeat("a", 300)); got != 100+messageTokenOverhead {
+		t.Errorf("Estimate = %d with default ratio", got)
+	}
+
+	// 4 chars per token
+	for i := 0; i < 20; i++ {
+		e.Observe("llama", 4000, 2, 1000+2*messageTokenOverhead)
+	}
+	if r := e.ratio("llama"); r < 3.9 || r > 4.1 {
+		t.Errorf("ratio = %f, expected about 4", r)
+	}
+
+	// Cached prompt reports few tokens, the sample is skipped
+	e.Observe("llama", 4000, 2, 100)
+	if r := e.ratio("llama"); r < 3.9 || r > 4.1 {
+		t.Errorf("ratio = %f after a cached sample", r)
+	}
+
+	if r := e.ratio("mistral"); r != defaultCharsPerToken {
+		t.Errorf("other models must keep the default ratio, got %f", r)
+	}
+}
+
+func TestMakeChatRequestFitsBudget(t *testing.T) {
+	b := &bot{config: &Config{Model: "llama", SystemPrompt: "be nice", NumCtx: 300, ContextReserve: 100}}
+	chat := NewChatContext(1, 100)
+	chat.Memory.Add("the cat is called Tom")
+
+	for i := 0; i < 20; i++ {
+		chat.History.Add(Message{UserType: UserTypeUser, Message: strings.Repeat("x", 60)})
+	}
+	newMsg := Message{UserType: UserTypeUser, Message: "latest"}
+	chat.History.Add(newMsg)
+
+	payload := b.makeChatRequest(context.Background(), chat, newMsg)
+
+	first, last := payload.Messages[0], payload.Messages[len(payload.Messages)-1]
+	if first.Role != string(UserTypeSystem) || !strings.Contains(first.Content, "Tom") {
+		t.Errorf("system message with memory must be first, got %+v", first)
+	}
+	if last.Content != "latest" {
+		t.Errorf("new message must be last, got %+v", last)
+	}
+	if payload.Messages[len(payload.Messages)-2].Content == "latest" {
+		t.Errorf("new message is duplicated")
+	}
+
+	history := len(payload.Messages) - 2
+	if history == 0 || history >= 20 {
+		t.Errorf("expected history to be trimmed, got %d messages", history)
+	}
+
+	used := 0
+	for _, msg := range payload.Messages {
+		used += b.tokens.Estimate("llama", msg.Content)
+	}
+	if used > 200 {
+		t.Errorf("prompt uses %d tokens, budget is 200", used)
+	}
+}
+
+func TestMakeChatRequestAfterQueueWait(t *testing.T) {
+	b := &bot{config: &Config{Model: "llama"}}
+	chat := NewChatContext(1, 100)
+
+	newMsg := Message{UserType: UserTypeUser, Message: "question"}
+	chat.History.Add(Message{UserType: UserTypeUser, Message: "before"})
+	chat.History.Add(newMsg)
+	// Came while the request waited in the queue
+	chat.History.Add(Message{UserType: UserTypeUser, Message: "after"})
+
+	payload := b.makeChatRequest(context.Background(), chat, newMsg)
+
+	var contents []string
+	for _, msg := range payload.Messages[1:] {
+		contents = append(contents, msg.Content)
+	}
+	if strings.Join(contents, "|") != "before|question" {
+		t.Errorf("unexpected messages %q", contents)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+const defaultMaxToolIterations = 5
+
+// ToolFunc is a go function the model can call, the returned string is sent back to the model
+type ToolFunc func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error)
+
+type tool struct {
+	definition ollama.Tool
+	fn         ToolFunc
+}
+
+type toolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]tool
+	order []string
+}
+
+func newToolRegistry() *toolRegistry {
+	return &toolRegistry{
+		tools: make(map[string]tool),
+	}
+}
+
+// Register adds a tool, parameters is a JSON schema of the arguments object
+func (r *toolRegistry) Register(name, description, parameters string, fn ToolFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !json.Valid([]byte(parameters)) {
+		panic(fmt.Sprintf("tool %s: invalid parameters schema", name))
+	}
+
+	if _, ok := r.tools[name]; !ok {
+		r.order = append(r.order, name)
+	}
+
+	r.tools[name] = tool{
+		definition: ollama.MakeFunctionTool(name, description, json.RawMessage(parameters)),
+		fn:         fn,
+	}
+}
+
+// Definitions returns tools in registration order, ready for ChatRequest.Tools
+func (r *toolRegistry) Definitions() []ollama.Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]ollama.Tool, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.tools[name].definition)
+	}
+	return definitions
+}
+
+// Call executes a tool call and wraps the result into a tool message
+func (r *toolRegistry) Call(ctx context.Context, chat *ChatContext, call ollama.ToolCall) ollama.Message {
+	name := call.Function.Name
+
+	r.mu.RLock()
+	t, ok := r.tools[name]
+	r.mu.RUnlock()
+
+	var result string
+	if !ok {
+		result = fmt.Sprintf("Error: unknown tool %q", name)
+	} else {
+		res, err := t.fn(ctx, chat, call.Function.Args)
+		if err != nil {
+			result = fmt.Sprintf("Error: %v", err)
+		} else {
+			result = res
+		}
+	}
+
+	logLLM.Info("Tool call", "tool", name, "args", fmt.Sprint(call.Function.Args), "result", result)
+
+	msg := ollama.MakeMessage(string(UserTypeTool), result)
+	msg.ToolName = name
+	return msg
+}
+
+func argString(args ollama.Args, key string) (string, error) {
+	v, ok := args[key]
+	if !ok {
+		return "", fmt.Errorf("missing argument %q", key)
+	}
+
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("argument %q must be a string", key)
+	}
+}
+
+func argInt(args ollama.Args, key string) (int, error) {
+	v, ok := args[key]
+	if !ok {
+		return 0, fmt.Errorf("missing argument %q", key)
+	}
+
+	switch n := v.(type) {
+	case float64:
+		return int(n), nil
+	case string:
+		// Small models often quote numbers
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("argument %q must be a number", key)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("argument %q must be a number", key)
+	}
+}
+
+func registerBuiltinTools(r *toolRegistry) {
+	r.Register("current_time",
+		"Get the current date and time",
+		`{"type":"object","properties":{}}`,
+		func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error) {
+			return time.Now().Format("Monday, 02 January 2006 15:04:05 MST"), nil
+		})
+
+	r.Register("memory_add",
+		"Remember a fact for this chat permanently",
+		`{"type":"object","properties":{"text":{"type":"string","description":"Fact to remember"}},"required":["text"]}`,
+		func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error) {
+			if roleFromContext(ctx) < RoleAdmin {
+				return "", fmt.Errorf("only chat admins can change the memory")
+			}
+			text, err := argString(args, "text")
+			if err != nil {
+				return "", err
+			}
+			chat.Memory.Add(text)
+			return "Remembered: " + text, nil
+		})
+
+	r.Register("memory_list",
+		"List facts remembered for this chat with their numbers",
+		`{"type":"object","properties":{}}`,
+		func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error) {
+			list := chat.Memory.GetList()
+			if list == "" {
+				return "Memory is empty", nil
+			}
+			return list, nil
+		})
+
+	r.Register("memory_remove",
+		"Forget a remembered fact by its number from memory_list",
+		`{"type":"object","properties":{"number":{"type":"integer","description":"Number of the fact, starting from 1"}},"required":["number"]}`,
+		func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error) {
+			if roleFromContext(ctx) < RoleAdmin {
+				return "", fmt.Errorf("only chat admins can change the memory")
+			}
+			number, err := argInt(args, "number")
+			if err != nil {
+				return "", err
+			}
+			old, ok := chat.Memory.Remove(number - 1)
+			if !ok {
+				return "", fmt.Errorf("no fact with number %d", number)
+			}
+			return "Forgot: " + old, nil
+		})
+
+	r.Register("link_preview",
+		"Get the title and description of a web page",
+		`{"type":"object","properties":{"url":{"type":"string","description":"https url of the page"}},"required":["url"]}`,
+		func(ctx context.Context, chat *ChatContext, args ollama.Args) (string, error) {
+			link, err := argString(args, "url")
+			if err != nil {
+				return "", err
+			}
+			preview := fetchPreview(ctx, link)
+			if preview == "" {
+				return "", fmt.Errorf("no preview available for %s", link)
+			}
+			return preview, nil
+		})
+}
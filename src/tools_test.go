@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+)
+
+func TestToolRegistryCall(t *testing.T) {
+	r := newToolRegistry()
+	registerBuiltinTools(r)
+
+	chat := NewChatContext(1, 10)
+	ctx := withRole(context.Background(), RoleAdmin)
+
+	definitions := r.Definitions()
+	if len(definitions) == 0 || definitions[0].Type != "function" || definitions[0].Function.Name != "current_time" {
+		t.Fatalf("unexpected definitions %+v", definitions)
+	}
+
+	msg := r.Call(context.Background(), chat, ollama.ToolCall{
+		Function: ollama.Function{Name: "memory_add", Args: ollama.Args{"text": "dogs"}},
+	})
+	if msg.Content != "Error: only chat admins can change the memory" || len(chat.Memory.Data) != 0 {
+		t.Errorf("expected users to be denied, got %q", msg.Content)
+	}
+
+	msg = r.Call(ctx, chat, ollama.ToolCall{
+		Function: ollama.Function{Name: "memory_add", Args: ollama.Args{"text": "cats are great"}},
+	})
+	if msg.Role != string(UserTypeTool) || msg.ToolName != "memory_add" {
+		t.Errorf("unexpected tool message %+v", msg)
+	}
+	if len(chat.Memory.Data) != 1 || chat.Memory.Data[0] != "cats are great" {
+		t.Errorf("memory not updated: %v", chat.Memory.Data)
+	}
+
+	msg = r.Call(ctx, chat, ollama.ToolCall{
+		Function: ollama.Function{Name: "memory_remove", Args: ollama.Args{"number": "1"}},
+	})
+	if msg.Content != "Forgot: cats are great" {
+		t.Errorf("unexpected result %q", msg.Content)
+	}
+
+	msg = r.Call(context.Background(), chat, ollama.ToolCall{Function: ollama.Function{Name: "unknown"}})
+	if msg.Content != `Error: unknown tool "unknown"` {
+		t.Errorf("unexpected result %q", msg.Content)
+	}
+}
+
+func TestSendRequestOllamaToolLoop(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		var req ollama.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == string(UserTypeTool) {
+			io.WriteString(w, `{"message":{"role":"assistant","content":"done: `+last.Content+`"},"done":true}`)
+			return
+		}
+
+		io.WriteString(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"memory_add","arguments":{"text":"x"}}}]},"done":true}`)
+	}))
+	defer srv.Close()
+
+	client, err := ollama.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bot{config: &Config{EnableTools: true}, ollama: client, tools: newToolRegistry()}
+	registerBuiltinTools(b.tools)
+
+	chat := NewChatContext(1, 10)
+	payload := b.makeChatRequest(context.Background(), chat, Message{UserType: UserTypeUser, Message: "remember x"})
+
+	res, err := b.sendRequestOllama(withRole(context.Background(), RoleAdmin), chat, payload, nil)
+	if err != nil {
+		t.Fatalf("sendRequestOllama: %v", err)
+	}
+	if res != "done: Remembered: x" || requests != 2 {
+		t.Errorf("unexpected result %q after %d requests", res, requests)
+	}
+}
+
+func TestSendRequestOllamaToolLimit(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		var req ollama.ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if len(req.Tools) == 0 {
+			io.WriteString(w, `{"message":{"role":"assistant","content":"final"},"done":true}`)
+			return
+		}
+
+		io.WriteString(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"current_time","arguments":{}}}]},"done":true}`)
+	}))
+	defer srv.Close()
+
+	client, err := ollama.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &bot{config: &Config{EnableTools: true, MaxToolIterations: 2}, ollama: client, tools: newToolRegistry()}
+	registerBuiltinTools(b.tools)
+
+	chat := NewChatContext(1, 10)
+	payload := b.makeChatRequest(context.Background(), chat, Message{UserType: UserTypeUser, Message: "time?"})
+
+	res, err := b.sendRequestOllama(context.Background(), chat, payload, nil)
+	if err != nil {
+		t.Fatalf("sendRequestOllama: %v", err)
+	}
+	if res != "final" || requests != 3 {
+		t.Errorf("unexpected result %q after %d requests", res, requests)
+	}
+}
+
+func TestCheckPreviewAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:443", "[::1]:443", "10.1.2.3:443", "172.16.0.1:443", "192.168.1.1:443", "169.254.169.254:80", "[fe80::1]:443", "[::ffff:127.0.0.1]:443", "0.0.0.0:443"} {
+		if err := checkPreviewAddr("tcp", addr, nil); err == nil {
+			t.Errorf("%s must be refused", addr)
+		}
+	}
+	if err := checkPreviewAddr("tcp", "93.184.215.14:443", nil); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestFetchPreviewRefusesLocal(t *testing.T) {
+	fetched := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte("<title>secret</title>"))
+	}))
+	defer server.Close()
+
+	for _, link := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		if preview := fetchPreview(context.Background(), link); preview != "" {
+			t.Errorf("%s: unexpected preview %q", link, preview)
+		}
+	}
+	if fetched {
+		t.Errorf("local server must not be requested")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/jeromeberg/ollama-telegram-bot/src/ollama"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	defaultMaxImageBytes = 1 << 20
+	defaultMaxImageSize  = 1344
+
+	// Telegram bot api does not allow downloading bigger files
+	maxDownloadBytes = 20 << 20
+
+	// Decoding allocates 4 bytes per pixel, a small file can declare huge dimensions
+	maxImagePixels = 50_000_000
+
+	visionLookupTimeout = 10 * time.Second
+)
+
+// visionCache remembers which models accept images
+type visionCache struct {
+	mu     sync.Mutex
+	models map[string]bool
+}
+
+// modelSupportsVision asks the chat provider for model capabilities once per provider and model
+func (b *bot) modelSupportsVision(chat *ChatContext) bool {
+	if !b.cfg().EnableVision {
+		return false
+	}
+
+	model := b.chatModel(chat)
+	key := b.chatProviderName(chat) + "/" + model
+
+	b.vision.mu.Lock()
+	supported, ok := b.vision.models[key]
+	b.vision.mu.Unlock()
+	if ok {
+		return supported
+	}
+
+	// The lock is not held during the request, a slow server must not block other chats
+	ctx, cancel := context.WithTimeout(context.Background(), visionLookupTimeout)
+	defer cancel()
+
+	supported, err := b.chatProvider(chat).SupportsVision(ctx, model)
+	if err != nil {
+		// Don't cache, the server may be temporary unavailable
+		logLLM.Error("Error checking model capabilities", "error", err)
+		return false
+	}
+
+	b.vision.mu.Lock()
+	if b.vision.models == nil {
+		b.vision.models = make(map[string]bool)
+	}
+	b.vision.models[key] = supported
+	b.vision.mu.Unlock()
+
+	logLLM.Info("Model vision support", "model", model, "supported", supported)
+
+	return supported
+}
+
+// imageFile returns the file of an image attached to the message, nil if there is none
+func imageFile(m *telebot.Message) *telebot.File {
+	switch {
+	case m == nil:
+		return nil
+	case m.Photo != nil:
+		return &m.Photo.File
+	case m.Document != nil && strings.HasPrefix(m.Document.MIME, "image/"):
+		return &m.Document.File
+	case m.Sticker != nil && (m.Sticker.Animated || m.Sticker.Video):
+		// Animated stickers are lottie or webm, use the static thumbnail
+		if m.Sticker.Thumbnail != nil {
+			return &m.Sticker.Thumbnail.File
+		}
+		return nil
+	case m.Sticker != nil:
+		return &m.Sticker.File
+	}
+
+	return nil
+}
+
+// describeMedia makes a text stand-in for a message that has only an image
+func describeMedia(m *telebot.Message) string {
+	switch {
+	case m.Sticker != nil:
+		return fmt.Sprintf("[sticker %s]", m.Sticker.Emoji)
+	case imageFile(m) != nil:
+		return "[image]"
+	}
+
+	return ""
+}
+
+// loadImages downloads images of the message (or the replied message) and prepares them for the model
+func (b *bot) loadImages(c telebot.Context) []ollama.ImageData {
+	file := imageFile(c.Message())
+	if file == nil {
+		file = imageFile(c.Message().ReplyTo)
+	}
+	if file == nil {
+		return nil
+	}
+
+	if file.FileSize > maxDownloadBytes {
+		logTelegram.Warn("Image is too big to download", "bytes", file.FileSize)
+		return nil
+	}
+
+	reader, err := c.Bot().File(file)
+	if err != nil {
+		logTelegram.Error("Error downloading image", "error", err)
+		return nil
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxDownloadBytes))
+	if err != nil {
+		logTelegram.Error("Error downloading image", "error", err)
+		return nil
+	}
+
+	maxBytes := b.cfg().MaxImageBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxImageBytes
+	}
+	maxSize := b.cfg().MaxImageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxImageSize
+	}
+
+	img, err := prepareImage(data, maxBytes, maxSize)
+	if err != nil {
+		logTelegram.Error("Error preparing image", "error", err)
+		return nil
+	}
+
+	return []ollama.ImageData{img}
+}
+
+// prepareImage fits an image into maxSize pixels on the longest side and maxBytes bytes,
+// images that already fit are passed as is
+func prepareImage(data []byte, maxBytes, maxSize int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too big", config.Width, config.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+
+	if len(data) <= maxBytes && longest <= maxSize && (format == "jpeg" || format == "png") {
+		return data, nil
+	}
+
+	scale := min(1, float64(maxSize)/float64(longest))
+	quality := 85
+
+	for {
+		encoded, err := encodeJPEG(img, scale, quality)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(encoded) <= maxBytes {
+			return encoded, nil
+		}
+
+		if quality > 60 {
+			quality -= 10
+		} else {
+			scale *= 0.75
+		}
+
+		if int(float64(longest)*scale) < 64 {
+			return nil, fmt.Errorf("can't fit image into %d bytes", maxBytes)
+		}
+	}
+}
+
+func encodeJPEG(img image.Image, scale float64, quality int) ([]byte, error) {
+	bounds := img.Bounds()
+	width := max(1, int(float64(bounds.Dx())*scale))
+	height := max(1, int(float64(bounds.Dy())*scale))
+
+	// Draw on white to flatten transparency, jpeg has no alpha channel
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
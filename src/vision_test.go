@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	// Random pixels compress badly, so the png stays big
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPrepareImageKeepsSmall(t *testing.T) {
+	data := makeTestPNG(t, 16, 16)
+
+	res, err := prepareImage(data, len(data), 100)
+	if err != nil {
+		t.Fatalf("prepareImage: %v", err)
+	}
+	if !bytes.Equal(res, data) {
+		t.Errorf("expected image to be passed as is")
+	}
+}
+
+func TestPrepareImageFitsBudget(t *testing.T) {
+	data := makeTestPNG(t, 800, 600)
+	maxBytes := 30 << 10
+
+	res, err := prepareImage(data, maxBytes, 400)
+	if err != nil {
+		t.Fatalf("prepareImage: %v", err)
+	}
+	if len(res) > maxBytes {
+		t.Errorf("image is %d bytes, budget %d", len(res), maxBytes)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	if format != "jpeg" || img.Bounds().Dx() > 400 || img.Bounds().Dy() > 300 {
+		t.Errorf("unexpected result %s %v", format, img.Bounds())
+	}
+}
+
+func TestPrepareImageInvalid(t *testing.T) {
+	if _, err := prepareImage([]byte("not an image"), 100, 100); err == nil {
+		t.Errorf("expected decode error")
+	}
+}
+
+func TestPrepareImageTooManyPixels(t *testing.T) {
+	data := makeTestPNG(t, 1, 1)
+
+	// Declare 100000x100000 pixels in the header, the file stays tiny
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := prepareImage(data, 1<<20, 1000); err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("expected a size error, got %v", err)
+	}
+}
+
+// blockingVisionProvider answers vision lookups when release is closed
+type blockingVisionProvider struct {
+	fakeProvider
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingVisionProvider) SupportsVision(ctx context.Context, model string) (bool, error) {
+	close(p.started)
+	<-p.release
+	return true, nil
+}
+
+func TestModelSupportsVisionDoesNotBlock(t *testing.T) {
+	slow := &blockingVisionProvider{started: make(chan struct{}), release: make(chan struct{})}
+	b := &bot{
+		config: &Config{
+			Model:         "llava",
+			EnableVision:  true,
+			ChatProviders: map[int64]string{1: "slow", 2: "fast"},
+		},
+		providers: map[string]Provider{"slow": slow, "fast": &fakeProvider{}},
+	}
+	b.vision.models = map[string]bool{"fast/llava": true}
+
+	done := make(chan bool)
+	go func() { done <- b.modelSupportsVision(NewChatContext(1, 10)) }()
+	<-slow.started
+
+	cached := make(chan bool)
+	go func() { cached <- b.modelSupportsVision(NewChatContext(2, 10)) }()
+	select {
+	case supported := <-cached:
+		if !supported {
+			t.Errorf("expected the cached answer")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a slow lookup blocks other chats")
+	}
+
+	close(slow.release)
+	if !<-done {
+		t.Errorf("expected vision support from the slow provider")
+	}
+	if !b.vision.models["slow/llava"] {
+		t.Errorf("the answer must be cached")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxWebhookBody      = 1 << 20
+)
+
+// webhookPoller receives updates that telegram posts to the webhook, it replaces the long poller when webhookUrl is set.
+// Updates are answered with 503 while the bot is not polling, telegram retries them later
+type webhookPoller struct {
+	secret string
+
+	mu   sync.RWMutex
+	dest chan<- telebot.Update
+	stop chan struct{}
+}
+
+func newWebhookPoller(secret string) *webhookPoller {
+	return &webhookPoller{secret: secret}
+}
+
+func (p *webhookPoller) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	p.mu.Lock()
+	p.dest, p.stop = dest, stop
+	p.mu.Unlock()
+
+	<-stop
+
+	p.mu.Lock()
+	p.dest, p.stop = nil, nil
+	p.mu.Unlock()
+}
+
+func (p *webhookPoller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(p.secret)) != 1 {
+		logTelegram.Warn("Webhook request with a wrong secret token", "remote", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update telebot.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&update); err != nil {
+		logTelegram.Warn("Error decoding webhook update", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.RLock()
+	dest, stop := p.dest, p.stop
+	p.mu.RUnlock()
+
+	if dest == nil {
+		http.Error(w, "not polling", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case dest <- update:
+	case <-stop:
+		http.Error(w, "not polling", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// webhookSettings describes the webhook registered in telegram
+func webhookSettings(config *Config) *telebot.Webhook {
+	wh := &telebot.Webhook{
+		Listen:      config.WebhookListen,
+		SecretToken: config.WebhookSecret,
+		Endpoint:    &telebot.WebhookEndpoint{PublicURL: config.WebhookURL},
+	}
+	if config.WebhookSelfSigned {
+		wh.Endpoint.Cert = config.WebhookCert
+	}
+	return wh
+}
+
+// serveWebhook starts the listener for webhook requests, TLS is used when a cert is configured
+func serveWebhook(config *Config, poller *webhookPoller) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              config.WebhookListen,
+		Handler:           poller,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if config.WebhookCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.WebhookCert, config.WebhookKey)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	// Listen here, so a busy port stops the bot at start
+	ln, err := net.Listen("tcp", config.WebhookListen)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		logTelegram.Info("Webhook listening", "addr", ln.Addr().String(), "url", config.WebhookURL)
+
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			err = server.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logTelegram.Error("Webhook server error", "error", err)
+		}
+	}()
+
+	return server, nil
+}
+
+// removeStaleWebhook deletes the webhook of an earlier webhook deployment, telegram refuses long polling while it is set
+func removeStaleWebhook(tgBot *telebot.Bot) {
+	wh, err := tgBot.Webhook()
+	if err != nil {
+		logTelegram.Warn("Error getting webhook info", "error", err)
+		return
+	}
+	// Listen holds the registered url
+	if wh.Listen == "" {
+		return
+	}
+
+	logTelegram.Warn("Removing webhook to use long polling", "url", wh.Listen, "pending", wh.PendingUpdates)
+	if err := tgBot.RemoveWebhook(); err != nil {
+		logTelegram.Error("Error removing webhook", "error", err)
+	}
+}
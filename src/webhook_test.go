@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+func postUpdate(p *webhookPoller, secret string) int {
+	req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id": 7, "message": {"text": "hi"}}`))
+	req.Header.Set(webhookSecretHeader, secret)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebhookPoller(t *testing.T) {
+	p := newWebhookPoller("s3cret")
+
+	if code := postUpdate(p, "s3cret"); code != http.StatusServiceUnavailable {
+		t.Errorf("updates before polling must be retried, got %d", code)
+	}
+
+	dest := make(chan telebot.Update, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Poll(nil, dest, stop)
+		close(done)
+	}()
+
+	// Wait for Poll to take the channels
+	for i := 0; postUpdate(p, "s3cret") == http.StatusServiceUnavailable; i++ {
+		if i == 100 {
+			t.Fatal("poller did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if update := <-dest; update.ID != 7 || update.Message.Text != "hi" {
+		t.Errorf("unexpected update %+v", update)
+	}
+
+	if code := postUpdate(p, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret must be rejected, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/telegram", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET must be rejected, got %d", rec.Code)
+	}
+
+	close(stop)
+	<-done
+	if code := postUpdate(p, "s3cret"); code != http.StatusServiceUnavailable {
+		t.Errorf("updates after stop must be retried, got %d", code)
+	}
+}
+
+func TestWebhookSettings(t *testing.T) {
+	config := &Config{WebhookURL: "https://bot.example.com/telegram", WebhookListen: ":8443", WebhookSecret: "s", WebhookCert: "cert.pem"}
+
+	wh := webhookSettings(config)
+	if wh.Endpoint.PublicURL != config.WebhookURL || wh.SecretToken != "s" || wh.Endpoint.Cert != "" {
+		t.Errorf("unexpected webhook %+v", wh)
+	}
+
+	config.WebhookSelfSigned = true
+	if wh := webhookSettings(config); wh.Endpoint.Cert != "cert.pem" {
+		t.Errorf("self-signed cert must be uploaded")
+	}
+}